@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/control"
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctlCmd = &cobra.Command{
+		Use:   "ctl",
+		Short: "Controls a running linkmeup",
+		Long: `Controls a linkmeup running in another terminal or in the background
+through its control socket.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Errors of a command used correctly don't need the usage
+			cmd.SilenceUsage = true
+		},
+	}
+
+	ctlStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Shows the state of all installations",
+		Args:  cobra.NoArgs,
+		RunE:  runCtlStatus,
+	}
+
+	ctlRestartCmd = &cobra.Command{
+		Use:   "restart NAME",
+		Short: "Restarts the tunnel of an installation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctlClient().Restart(cmd.Context(), args[0])
+		},
+	}
+
+	ctlSwitchCmd = &cobra.Command{
+		Use:   "switch NAME [NODE]",
+		Short: "Moves the tunnel of an installation to another node",
+		Long: `Moves the tunnel of an installation to the given node, or to a different
+one picked at random if no node is given.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			node := ""
+			if len(args) > 1 {
+				node = args[1]
+			}
+			return ctlClient().SwitchNode(cmd.Context(), args[0], node)
+		},
+	}
+
+	ctlEnableCmd = &cobra.Command{
+		Use:   "enable NAME",
+		Short: "Starts the proxy of an installation that was disabled",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctlClient().Enable(cmd.Context(), args[0])
+		},
+	}
+
+	ctlDisableCmd = &cobra.Command{
+		Use:   "disable NAME",
+		Short: "Stops the proxy of an installation until it is enabled again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctlClient().Disable(cmd.Context(), args[0])
+		},
+	}
+
+	ctlReloadCmd = &cobra.Command{
+		Use:   "reload",
+		Short: "Reloads the health checks and hosts of the installations from the config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctlClient().Reload(cmd.Context())
+		},
+	}
+
+	ctlStopCmd = &cobra.Command{
+		Use:   "stop",
+		Short: "Stops linkmeup",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctlClient().Shutdown(cmd.Context())
+		},
+	}
+)
+
+func init() {
+	ctlCmd.AddCommand(ctlStatusCmd, ctlRestartCmd, ctlSwitchCmd, ctlEnableCmd, ctlDisableCmd, ctlReloadCmd, ctlStopCmd)
+	rootCmd.AddCommand(ctlCmd)
+}
+
+func ctlClient() *control.Client {
+	return control.NewClient(socketPath)
+}
+
+func runCtlStatus(cmd *cobra.Command, args []string) error {
+	status, err := ctlClient().Status(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tDOMAIN\tSTATE\tPORT\tNODES\tACTIVE NODE")
+	for _, p := range status.Proxies {
+		node := p.ActiveNode
+		if node == "" {
+			node = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", p.Name, p.Domain, p.State, p.Port, len(p.Nodes), node)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	sessions := status.TeleportProfiles
+	if len(sessions) == 0 && status.Teleport != nil {
+		sessions = []pacserver.TeleportStatus{*status.Teleport}
+	}
+	if len(sessions) > 0 {
+		fmt.Println()
+	}
+	for _, t := range sessions {
+		profile := ""
+		if t.Profile != "" {
+			profile = fmt.Sprintf(" (profile %s)", t.Profile)
+		}
+		fmt.Printf("Teleport session of %s on %s%s valid until %s (%s left)\n",
+			t.Username, t.Cluster, profile,
+			t.ValidUntil.Local().Format(time.DateTime),
+			max(time.Until(t.ValidUntil), 0).Round(time.Minute))
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/conf"
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshfake"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+func TestMain(m *testing.M) {
+	tshfake.Main()
+	os.Exit(m.Run())
+}
+
+// Returns a port nothing listens on right now.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Returns the first of n consecutive ports nothing listens on right now.
+func freePorts(t *testing.T, n int) int {
+	t.Helper()
+	for range 100 {
+		first := freePort(t)
+		free := true
+		for port := first + 1; port < first+n && free; port++ {
+			l, err := net.Listen("tcp", net.JoinHostPort("localhost", fmt.Sprint(port)))
+			if err != nil {
+				free = false
+				continue
+			}
+			_ = l.Close()
+		}
+		if free {
+			return first
+		}
+	}
+	t.Fatalf("found no %d consecutive free ports", n)
+	return 0
+}
+
+// Waits until cond is true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func waitForStates(t *testing.T, proxies []*proxy.Proxy, want ...proxy.State) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("states %v", want), func() bool {
+		for i, p := range proxies {
+			if p.Status().State != want[i] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Returns the PAC file served by the server.
+func fetchPAC(t *testing.T, server *pacserver.PacServer) string {
+	t.Helper()
+	resp, err := http.Get(server.URL()) //nolint:noctx
+	if err != nil {
+		t.Fatalf("failed to get PAC file: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read PAC file: %v", err)
+	}
+	return string(body)
+}
+
+// Runs linkmeup headless against a fake tsh with two installations, one on
+// each of two Teleport proxies, from startup through a node failing and a
+// session expiring to the login that renews it.
+func TestEndToEnd(t *testing.T) {
+	proxy.SetStartPort(freePorts(t, 2))
+
+	fake := tshfake.New(t)
+	fake.Login("other.example.com", time.Now().Add(time.Hour), "root")
+	fake.Login("teleport.example.com", time.Now().Add(time.Hour), "root")
+	fake.SetNodes("ins=one,cluster=one,role=control-plane", "one-a", "one-b")
+	fake.SetNodes("ins=two,cluster=two,role=control-plane", "two-a", "two-b")
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+	fake.Route("happaapi.one.example:80", api.Listener.Addr().String())
+	fake.Route("happaapi.two.example:80", api.Listener.Addr().String())
+
+	savedConfig, savedLogger, savedRunner := config, logger, tshRunner
+	t.Cleanup(func() { config, logger, tshRunner = savedConfig, savedLogger, savedRunner })
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	tshRunner = fake
+
+	// Frequent checks, but not so frequent that tunnels are replaced while
+	// the slow test binary starts as tsh
+	check := conf.HealthCheck{URL: "http://happaapi.{{.Domain}}/healthz", Interval: time.Second}
+	config = conf.Config{
+		Installations: []conf.Installation{
+			{Name: "one", Domain: "one.example", Check: check},
+			{Name: "two", Domain: "two.example", Check: check, Teleport: "other"},
+		},
+		Teleport: conf.Teleport{
+			Proxy:         "teleport.example.com",
+			Profiles:      []conf.TeleportProfile{{Name: "other", Proxy: "other.example.com", Cluster: "leaf"}},
+			CheckInterval: 100 * time.Millisecond,
+		},
+		Proxy: conf.Proxy{SOCKS5Port: defaultSOCKS5Port},
+		PAC: conf.PAC{
+			Port:      freePort(t),
+			Address:   pacserver.DefaultAddress,
+			Paths:     pacserver.DefaultPaths,
+			ProxyType: pacserver.ProxyTypeSOCKS5,
+			Unhealthy: pacserver.UnhealthyOmit,
+		},
+	}
+
+	// Startup
+	status, err := checkTeleport()
+	if err != nil {
+		t.Fatalf("checkTeleport() error = %v", err)
+	}
+	err = validateLogins(status)
+	if err != nil {
+		t.Fatalf("validateLogins() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proxies, err := startProxies(ctx)
+	if err != nil {
+		t.Fatalf("startProxies() error = %v", err)
+	}
+	defer stopProxies(proxies)
+
+	pac, err := startWebserver(ctx, proxies, nil)
+	if err != nil {
+		t.Fatalf("startWebserver() error = %v", err)
+	}
+	_, err = watchSessions(ctx, status, proxies, func(name string, profile *tshstatus.Profile, status *tshstatus.Status) {
+		pac.SetTeleportStatus(status)
+		pac.SetTeleportSession(name, profile)
+	})
+	if err != nil {
+		t.Fatalf("watchSessions() error = %v", err)
+	}
+
+	waitForStates(t, proxies, proxy.StateHealthy, proxy.StateHealthy)
+	for _, args := range fake.Called("ssh") {
+		line := strings.Join(args, " ")
+		if strings.HasSuffix(line, ",ins=two,cluster=two,role=control-plane") && !strings.HasPrefix(line, "ssh --proxy other.example.com --cluster leaf ") {
+			t.Errorf("tunnel of two opened with %q, want the flags of its Teleport profile", line)
+		}
+	}
+
+	// PAC generation
+	want := "  if (dnsDomainIs(host, 'one.example')) { return 'SOCKS5 localhost:1080'; }\n"
+	if body := fetchPAC(t, pac); !strings.Contains(body, want) || !strings.Contains(body, "two.example") {
+		t.Errorf("PAC file = %q, want both installations", body)
+	}
+
+	// Failover to the other node once the node of the tunnel goes down
+	node := proxies[0].Status().ActiveNode
+	fake.SetDown(node, true)
+	waitFor(t, "failover", func() bool {
+		s := proxies[0].Status()
+		return s.State == proxy.StateHealthy && s.ActiveNode != node
+	})
+
+	// Only the tunnel of the expired session stops
+	fake.Expire("other.example.com")
+	waitForStates(t, proxies, proxy.StateHealthy, proxy.StateAuthExpired)
+	if body := fetchPAC(t, pac); strings.Contains(body, "two.example") {
+		t.Errorf("PAC file = %q, want the installation of the expired session omitted", body)
+	}
+
+	// And starts again after a new login
+	fake.Login("other.example.com", time.Now().Add(time.Hour), "root")
+	waitForStates(t, proxies, proxy.StateHealthy, proxy.StateHealthy)
+	if body := fetchPAC(t, pac); !strings.Contains(body, "two.example") {
+		t.Errorf("PAC file = %q, want both installations again", body)
+	}
+
+	// The session of each profile is reported, not just the active one
+	sessions := pac.Status().TeleportProfiles
+	if len(sessions) != 2 || sessions[0].Profile != "teleport.example.com" || sessions[1].Profile != "other" || sessions[1].Proxy != "other.example.com" {
+		t.Fatalf("TeleportProfiles = %+v, want the default and the other profile", sessions)
+	}
+	if !sessions[1].ValidUntil.After(time.Now()) {
+		t.Errorf("session of other valid until %s, want the new login", sessions[1].ValidUntil)
+	}
+}
@@ -1,33 +1,67 @@
 package cmd
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/giantswarm/linkmeup/pkg/conf"
+	"github.com/giantswarm/linkmeup/pkg/control"
+	"github.com/giantswarm/linkmeup/pkg/dashboard"
+	"github.com/giantswarm/linkmeup/pkg/frontend"
+	"github.com/giantswarm/linkmeup/pkg/logging"
+	"github.com/giantswarm/linkmeup/pkg/metrics"
 	"github.com/giantswarm/linkmeup/pkg/pacserver"
 	"github.com/giantswarm/linkmeup/pkg/proxy"
 	"github.com/giantswarm/linkmeup/pkg/tshstatus"
 	"github.com/giantswarm/linkmeup/pkg/tui"
 
+	"github.com/charmbracelet/x/term"
 	"github.com/lmittmann/tint"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-const pacPort = 9999
+const (
+	defaultPACPort = 9999
+
+	defaultCheckURL = "https://happaapi.{{.Domain}}/healthz"
+	defaultSelector = "ins={{.Name}},cluster={{.Name}},role=control-plane"
+	defaultLogin    = "root"
+
+	defaultSOCKS5Port = 1080
+
+	defaultUpstreamRefresh = time.Hour
+
+	// Log file rotation, size in megabytes
+	defaultLogMaxSize    = 10
+	defaultLogMaxBackups = 3
+	// Number of log entries kept for the log pane of the TUI
+	logBufferSize = 500
+)
 
 var (
 	// Used for flags.
-	cfgFile  string
-	logLevel string
-	config   conf.Config
+	cfgFile    string
+	logLevel   string
+	logFormat  string
+	socketPath string
+	headless   bool
+	config     conf.Config
+	// Serializes reloads of config
+	reloadMu sync.Mutex
 
 	rootCmd = &cobra.Command{
 		Use:   "linkmeup",
@@ -41,10 +75,19 @@ it requires:
 
 The command will serve a proxy auto configuration (PAC) file on
 
-  http://localhost:9999/proxy.pac
+  http://127.0.0.1:9999/proxy.pac
 
 You can use this to configure your browser or operating system to use the proxies.
+Alternatively, point clients directly at the SOCKS5 proxy on localhost:1080,
+which forwards connections to the right installation based on the host name.
+For clients without SOCKS5 support, an HTTP proxy can be enabled in the config.
+
+While running, linkmeup can be controlled with 'linkmeup ctl'. Use --headless
+or 'linkmeup serve' to run it without the terminal UI, logging to stdout.
 `,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			initConfig()
+		},
 		RunE: runRootCommand,
 	}
 
@@ -57,10 +100,11 @@ func Execute() error {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
-
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default $HOME/.config/linkmeup.yaml)")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "set the log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "set the log format (text, json)")
+	rootCmd.PersistentFlags().StringVar(&socketPath, "socket", control.DefaultSocketPath(), "control socket of the running linkmeup")
+	rootCmd.Flags().BoolVar(&headless, "headless", false, "run without the terminal UI and log to stdout, same as 'linkmeup serve'")
 }
 
 func initConfig() {
@@ -80,28 +124,43 @@ func initConfig() {
 	}
 
 	viper.AutomaticEnv()
+	viper.SetDefault("proxy.socks5_port", defaultSOCKS5Port)
+	viper.SetDefault("pac.port", defaultPACPort)
+	viper.SetDefault("pac.address", pacserver.DefaultAddress)
+	viper.SetDefault("pac.paths", pacserver.DefaultPaths)
+	viper.SetDefault("pac.proxy_type", pacserver.ProxyTypeSOCKS5)
+	viper.SetDefault("pac.unhealthy", pacserver.UnhealthyProxy)
+	viper.SetDefault("pac.upstream_refresh", defaultUpstreamRefresh)
+	viper.SetDefault("pac.dashboard", true)
+	viper.SetDefault("teleport.check_interval", tshstatus.DefaultCheckInterval)
+	viper.SetDefault("teleport.warn_before", tshstatus.DefaultWarnBefore)
+	viper.SetDefault("log.file", logging.DefaultPath())
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
+	viper.SetDefault("log.max_size", defaultLogMaxSize)
+	viper.SetDefault("log.max_backups", defaultLogMaxBackups)
 
 	// Add a logger to the root command
-	level := slog.LevelInfo
-	switch logLevel {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
+	level, err := parseLevel(logLevel)
+	if err != nil {
+		fmt.Printf("Invalid log level: %s. Valid options are: debug, info, warn, error.\n", logLevel)
+		os.Exit(1)
+	}
+	switch logFormat {
+	case "text":
+		logger = slog.New(tint.NewTextHandler(os.Stdout, &tint.Options{
+			Level:      level,
+			TimeFormat: "Jan 02 15:04:05",
+			NoColor:    !isTerminal(os.Stdout),
+		}))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 	default:
-		fmt.Printf("Invalid log level: %s. Valid options are: debug, info, warn, error, fatal.\n", logLevel)
+		fmt.Printf("Invalid log format: %s. Valid options are: text, json.\n", logFormat)
 		os.Exit(1)
 	}
-	logger = slog.New(tint.NewTextHandler(os.Stdout, &tint.Options{
-		Level:      level,
-		TimeFormat: "Jan 02 15:04:05",
-	}))
 
-	err := viper.ReadInConfig()
+	err = viper.ReadInConfig()
 	if err != nil {
 		logger.Error("Error reading config file", slog.String("error", err.Error()))
 		os.Exit(1)
@@ -115,77 +174,246 @@ func initConfig() {
 		os.Exit(1)
 	}
 
-	if len(config.Installations) == 0 {
-		logger.Error("No installations found in config file")
+	err = validateConfig(config)
+	if err != nil {
+		logger.Error("Invalid config", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 }
 
-func runRootCommand(cmd *cobra.Command, args []string) error {
-	logger.Debug("Starting linkmeup", slog.String("log_level", logLevel))
+// Checks the settings that can't be checked by the components they belong
+// to.
+func validateConfig(c conf.Config) error {
+	if len(c.Installations) == 0 {
+		return fmt.Errorf("no installations found in config file")
+	}
+
+	if c.PAC.ProxyType == pacserver.ProxyTypeHTTP && c.Proxy.HTTPPort == 0 {
+		return fmt.Errorf("PAC proxy type 'http' requires proxy.http_port to be set")
+	}
+
+	err := validateProfiles(c)
+	if err != nil {
+		return err
+	}
 
-	// Build login command to show to user in case of error
-	teleportProxy := config.Teleport.Proxy
-	if teleportProxy == "" {
-		teleportProxy = "PROXY"
+	if c.Teleport.CheckInterval <= 0 || c.Teleport.WarnBefore < 0 {
+		return fmt.Errorf("teleport.check_interval must be positive and teleport.warn_before must not be negative")
 	}
-	auth := config.Teleport.Auth
-	if auth == "" {
-		auth = "AUTH"
+
+	if _, err := parseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("invalid log.level: %w", err)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("invalid log.format %q, valid options are text and json", c.Log.Format)
 	}
-	loginCmd := fmt.Sprintf("tsh login --proxy %s --auth %s", teleportProxy, auth)
 
-	status, err := tshstatus.GetStatus(logger)
+	return nil
+}
+
+// Parses a log level name.
+func parseLevel(name string) (slog.Level, error) {
+	switch name {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// Sets up the logger used once the proxies start. It writes to the log file
+// if enabled, and to stdout when running headless or to a buffer shown in
+// the log pane of the TUI otherwise. The log file is returned to be closed
+// on exit, the buffer for the TUI. Either may be nil.
+func setupLogging() (*logging.RotatingFile, *logging.Buffer, error) {
+	// Checked by validateConfig already
+	level, _ := parseLevel(config.Log.Level)
+
+	var handlers []slog.Handler
+	if headless {
+		handlers = append(handlers, logger.Handler())
+	}
+
+	var file *logging.RotatingFile
+	if config.Log.File != "" {
+		var err error
+		file, err = logging.OpenRotatingFile(config.Log.File, int64(config.Log.MaxSize)<<20, config.Log.MaxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up log file: %w", err)
+		}
+
+		opts := &slog.HandlerOptions{Level: level}
+		if config.Log.Format == "json" {
+			handlers = append(handlers, slog.NewJSONHandler(file, opts))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(file, opts))
+		}
+	}
+
+	var buffer *logging.Buffer
+	if !headless {
+		buffer = logging.NewBuffer(logBufferSize, level)
+		handlers = append(handlers, buffer.Handler())
+	}
+
+	logger = slog.New(logging.Multi(handlers...))
+	return file, buffer, nil
+}
+
+// Reads the config file again and applies the health checks and hosts of
+// the installations to the running proxies. Nothing is applied if the
+// config is invalid or installations were added, removed or got another
+// domain, which needs a restart. The reloaded config replaces config, the
+// other settings in it take effect with the next restart.
+func reloadConfig(proxies []*proxy.Proxy) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var c conf.Config
+	err = viper.Unmarshal(&c)
+	if err != nil {
+		return fmt.Errorf("failed to decode config file: %w", err)
+	}
+	err = validateConfig(c)
 	if err != nil {
-		if errors.Is(err, tshstatus.ErrNotLoggedIn) || errors.Is(err, tshstatus.ErrActiveProfileExpired) {
-			fmt.Printf("Error: You are not logged in to Teleport. Please log in using '%s'.\n", loginCmd)
-			os.Exit(1)
+		return err
+	}
+	if len(c.Installations) != len(proxies) {
+		return fmt.Errorf("installations were added or removed, restart linkmeup to apply")
+	}
+
+	checks := make([]proxy.HealthCheck, len(proxies))
+	allHosts := make([]proxy.Hosts, len(proxies))
+	for i, p := range proxies {
+		idx := slices.IndexFunc(c.Installations, func(inst conf.Installation) bool {
+			return inst.Name == p.Name
+		})
+		if idx < 0 {
+			return fmt.Errorf("installation %s was removed, restart linkmeup to apply", p.Name)
 		}
-		if errors.Is(err, tshstatus.ErrNoValidKeyPair) {
-			fmt.Printf("Error: Your Teleport key pair is not valid. Please log out using 'tsh logout' and then log in using '%s'.\n", loginCmd)
-			os.Exit(1)
+		inst := c.Installations[idx]
+		if inst.Domain != p.Domain {
+			return fmt.Errorf("domain of %s changed, restart linkmeup to apply", p.Name)
 		}
 
-		return fmt.Errorf("failed to get tsh status: %w", err)
+		checks[i], err = healthCheck(inst)
+		if err != nil {
+			return fmt.Errorf("invalid health check for %s: %w", inst.Name, err)
+		}
+		allHosts[i], err = hosts(inst)
+		if err != nil {
+			return fmt.Errorf("invalid hosts for %s: %w", inst.Name, err)
+		}
 	}
 
-	if status == nil || status.Active == nil || status.Active.ProfileURL == "" {
-		fmt.Println("Error: No active Teleport profile found. Please log in using 'tsh login'.")
-		os.Exit(1)
+	for i, p := range proxies {
+		p.SetCheck(checks[i])
+		p.SetHosts(allHosts[i])
 	}
-	logger.Debug("Active Teleport profile found", slog.String("cluster", status.Active.Cluster), slog.Time("valid_until", status.Active.ValidUntil))
+	config = c
+	logger.Info("Reloaded config file", slog.String("path", viper.ConfigFileUsed()))
+
+	return nil
+}
 
-	// Silence the logger during TUI operation - redirect to discard
-	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+func runRootCommand(cmd *cobra.Command, args []string) error {
+	start := time.Now()
+	logger.Debug("Starting linkmeup", slog.String("log_level", logLevel))
 
-	proxies, err := startProxies()
+	status, err := checkTeleport()
 	if err != nil {
 		return err
 	}
 
-	err = startWebserver(proxies)
+	err = validateLogins(status)
 	if err != nil {
 		return err
 	}
 
-	// Set up signal handling for graceful shutdown
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	if !headless && !isTerminal(os.Stdin) {
+		return fmt.Errorf("no terminal for the UI found, use --headless or 'linkmeup serve' to run without it")
+	}
 
-	go func() {
-		<-sigs
-		stopProxies(proxies)
-		os.Exit(0)
-	}()
+	// From here on, the TUI owns the terminal unless running headless
+	logFile, logs, err := setupLogging()
+	if err != nil {
+		return err
+	}
+	if logFile != nil {
+		defer func() { _ = logFile.Close() }()
+	}
+	logger.Info("Starting proxies", slog.Int("installations", len(config.Installations)))
+
+	// Everything started below shuts down when ctx is cancelled, either by
+	// a signal, a shutdown request or when the TUI exits.
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
 
-	// Run the TUI - this blocks until the user quits
-	err = tui.Run(proxies, pacPort)
+	proxies, err := startProxies(ctx)
 	if err != nil {
-		return fmt.Errorf("TUI error: %w", err)
+		return err
+	}
+	defer stopProxies(proxies)
+
+	frontends, err := startFrontends(ctx, proxies)
+	if err != nil {
+		return err
+	}
+	defer stopFrontends(frontends)
+
+	var m *metrics.Metrics
+	if config.PAC.Metrics {
+		m = metrics.New(start)
+		go m.Run(ctx, proxies)
+	}
+
+	pacServer, err := startWebserver(ctx, proxies, m)
+	if err != nil {
+		return err
+	}
+	pacServer.SetTeleportStatus(status)
+
+	err = startControl(ctx, proxies, pacServer, shutdown)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := watchSessions(ctx, status, proxies, func(name string, profile *tshstatus.Profile, status *tshstatus.Status) {
+		pacServer.SetTeleportStatus(status)
+		pacServer.SetTeleportSession(name, profile)
+		if m != nil {
+			m.SetSession(name, profile)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if headless {
+		logger.Info("linkmeup is running, stop it with Ctrl + C, SIGTERM or 'linkmeup ctl stop'", slog.String("pac_url", pacServer.URL()), slog.Int("socks5_port", config.Proxy.SOCKS5Port))
+		<-ctx.Done()
+		logger.Info("Shutting down")
+		return nil
 	}
 
-	// Clean up proxies when TUI exits
-	stopProxies(proxies)
+	// Run the TUI - this blocks until the user quits or a signal arrives
+	err = tui.Run(ctx, proxies, logs, sessions, pacServer.URL(), config.Proxy.SOCKS5Port, config.Proxy.HTTPPort)
+	if err != nil {
+		return fmt.Errorf("TUI error: %w", err)
+	}
 
 	return nil
 }
@@ -194,35 +422,264 @@ func stopProxies(proxies []*proxy.Proxy) {
 	for _, p := range proxies {
 		err := p.Stop()
 		if err != nil {
-			// Can't log to TUI anymore, just continue
-			continue
+			// Only visible when running headless
+			logger.Error("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
 		}
 	}
 }
 
+// Returns whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(f.Fd())
+}
+
 // Starts a Teleport port-forward process for each entry in privateInstallations.
-func startProxies() ([]*proxy.Proxy, error) {
+// The proxies are stopped when ctx is cancelled.
+func startProxies(ctx context.Context) ([]*proxy.Proxy, error) {
 	proxies := make([]*proxy.Proxy, 0, len(config.Installations))
 	for _, inst := range config.Installations {
-		checkEndpoint := fmt.Sprintf("https://happaapi.%s/healthz", inst.Domain)
-		p, err := proxy.New(logger, inst.Name, inst.Domain, checkEndpoint)
+		check, err := healthCheck(inst)
 		if err != nil {
+			stopProxies(proxies)
+			return nil, fmt.Errorf("invalid health check for %s: %w", inst.Name, err)
+		}
+
+		backend, err := tshBackend(inst)
+		if err != nil {
+			stopProxies(proxies)
+			return nil, fmt.Errorf("invalid Teleport settings for %s: %w", inst.Name, err)
+		}
+
+		h, err := hosts(inst)
+		if err != nil {
+			stopProxies(proxies)
+			return nil, fmt.Errorf("invalid hosts for %s: %w", inst.Name, err)
+		}
+
+		p, err := proxy.New(logger, inst.Name, inst.Domain, check, backend)
+		if err != nil {
+			stopProxies(proxies)
 			return nil, fmt.Errorf("failed to start proxy for %s: %w", inst.Name, err)
 		}
 
-		p.PingConstantly()
+		p.SetHosts(h)
+		p.PingConstantly(ctx)
 
 		proxies = append(proxies, p)
 	}
 	return proxies, nil
 }
 
-func startWebserver(proxies []*proxy.Proxy) error {
-	server, err := pacserver.New(logger, proxies, pacPort)
+func stopFrontends(frontends []io.Closer) {
+	for _, f := range frontends {
+		_ = f.Close()
+	}
+}
+
+// Starts the SOCKS5 proxy, and the HTTP proxy if enabled, that route client
+// connections to the tunnels. They stop when ctx is cancelled.
+func startFrontends(ctx context.Context, proxies []*proxy.Proxy) ([]io.Closer, error) {
+	socksServer, err := frontend.NewSOCKS5Server(logger, proxies, config.Proxy.SOCKS5Port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 proxy: %w", err)
+	}
+
+	err = socksServer.Serve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SOCKS5 proxy: %w", err)
+	}
+
+	frontends := []io.Closer{socksServer}
+	if config.Proxy.HTTPPort == 0 {
+		return frontends, nil
+	}
+
+	httpServer, err := frontend.NewHTTPServer(logger, proxies, config.Proxy.HTTPPort)
+	if err != nil {
+		stopFrontends(frontends)
+		return nil, fmt.Errorf("failed to create HTTP proxy: %w", err)
+	}
+
+	err = httpServer.Serve(ctx)
+	if err != nil {
+		stopFrontends(frontends)
+		return nil, fmt.Errorf("failed to start HTTP proxy: %w", err)
+	}
+
+	return append(frontends, httpServer), nil
+}
+
+// Returns the SSH login configured for an installation.
+func login(inst conf.Installation) string {
+	if inst.Login == "" {
+		return defaultLogin
+	}
+	return inst.Login
+}
+
+// Builds the tunnel backend for an installation from its config.
+func tshBackend(inst conf.Installation) (*proxy.TshBackend, error) {
+	selectorTemplate := inst.Selector
+	if selectorTemplate == "" {
+		selectorTemplate = defaultSelector
+	}
+	selector, err := renderTemplate(selectorTemplate, inst)
 	if err != nil {
-		return fmt.Errorf("failed to create PAC server: %w", err)
+		return nil, fmt.Errorf("selector: %w", err)
 	}
 
-	server.Serve()
+	profile, err := teleportProfile(config, inst)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := proxy.NewTshBackend(selector, inst.Query, login(inst))
+	if err != nil {
+		return nil, err
+	}
+	backend.Proxy = profile.Proxy
+	backend.Cluster = profile.Cluster
+	backend.Runner = tshRunner
+	return backend, nil
+}
+
+// Renders a config value that may refer to the installation's settings.
+func renderTemplate(text string, inst conf.Installation) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, inst)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+// Builds the hosts an installation's proxy is used for from its config.
+func hosts(inst conf.Installation) (proxy.Hosts, error) {
+	networks := make([]*net.IPNet, 0, len(inst.Networks))
+	for _, n := range inst.Networks {
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return proxy.Hosts{}, fmt.Errorf("failed to parse network: %w", err)
+		}
+		networks = append(networks, network)
+	}
+
+	return proxy.Hosts{
+		Domains:  inst.Domains,
+		Patterns: inst.Patterns,
+		Exclude:  inst.Exclude,
+		Networks: networks,
+	}, nil
+}
+
+// Builds the health check for an installation from its config.
+func healthCheck(inst conf.Installation) (proxy.HealthCheck, error) {
+	c := inst.Check
+
+	urlTemplate := c.URL
+	if urlTemplate == "" {
+		urlTemplate = defaultCheckURL
+	}
+	url, err := renderTemplate(urlTemplate, inst)
+	if err != nil {
+		return proxy.HealthCheck{}, fmt.Errorf("URL: %w", err)
+	}
+
+	var bodyMatch *regexp.Regexp
+	if c.BodyMatch != "" {
+		bodyMatch, err = regexp.Compile(c.BodyMatch)
+		if err != nil {
+			return proxy.HealthCheck{}, fmt.Errorf("failed to parse body_match: %w", err)
+		}
+	}
+
+	if c.Timeout < 0 || c.Interval < 0 {
+		return proxy.HealthCheck{}, fmt.Errorf("timeout and interval must not be negative")
+	}
+
+	return proxy.HealthCheck{
+		URL:            url,
+		Method:         strings.ToUpper(c.Method),
+		ExpectedStatus: c.ExpectedStatus,
+		BodyMatch:      bodyMatch,
+		Timeout:        c.Timeout,
+		Interval:       c.Interval,
+	}, nil
+}
+
+// Starts the PAC server, which shuts down when ctx is cancelled. It also
+// serves the metrics if m is not nil, and the dashboard if enabled.
+func startWebserver(ctx context.Context, proxies []*proxy.Proxy, m *metrics.Metrics) (*pacserver.PacServer, error) {
+	proxyPort := config.Proxy.SOCKS5Port
+	if config.PAC.ProxyType == pacserver.ProxyTypeHTTP {
+		proxyPort = config.Proxy.HTTPPort
+	}
+
+	server, err := pacserver.New(logger, proxies, config.PAC.Port, config.PAC.ProxyType, proxyPort, config.PAC.Unhealthy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PAC server: %w", err)
+	}
+
+	for i, path := range config.PAC.Paths {
+		if !strings.HasPrefix(path, "/") || slices.Contains(config.PAC.Paths[:i], path) {
+			return nil, fmt.Errorf("invalid PAC path %q, paths must start with / and be unique", path)
+		}
+	}
+	server.Address = config.PAC.Address
+	server.Paths = config.PAC.Paths
+
+	if config.PAC.Upstream != "" {
+		upstream, err := pacserver.NewUpstream(logger, config.PAC.Upstream, config.PAC.UpstreamRefresh)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream PAC file: %w", err)
+		}
+		// Like off VPN, the upstream PAC file may not be reachable yet.
+		// Hosts go directly until a refresh loads it.
+		err = upstream.Load(ctx)
+		if err != nil {
+			logger.Warn("Failed to load upstream PAC file, connecting other hosts directly until it loads", slog.String("error", err.Error()))
+		}
+		go upstream.Run(ctx)
+		server.Upstream = upstream
+	}
+
+	if m != nil {
+		server.Handle("GET /metrics", m.Handler())
+	}
+	if config.PAC.Dashboard {
+		d := dashboard.New(logger, proxies, server)
+		server.Handle(dashboard.Path, d.Handler())
+		go d.Run(ctx)
+	}
+
+	err = server.Serve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start PAC server: %w", err)
+	}
+	return server, nil
+}
+
+// Starts the control API on the socket, which shuts down when ctx is
+// cancelled. Shutdown requests call shutdown.
+func startControl(ctx context.Context, proxies []*proxy.Proxy, pacServer *pacserver.PacServer, shutdown func()) error {
+	server, err := control.NewServer(logger, socketPath, proxies, pacServer)
+	if err != nil {
+		return fmt.Errorf("failed to create control API: %w", err)
+	}
+	server.Reload = func() error {
+		return reloadConfig(proxies)
+	}
+	server.Shutdown = shutdown
+
+	err = server.Serve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start control API: %w", err)
+	}
 	return nil
 }
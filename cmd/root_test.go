@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/conf"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+
+	"github.com/spf13/viper"
+)
+
+func Test_healthCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		check     conf.HealthCheck
+		want      proxy.HealthCheck
+		wantMatch string
+		wantErr   bool
+	}{
+		{
+			name:  "defaults",
+			check: conf.HealthCheck{},
+			want:  proxy.HealthCheck{URL: "https://happaapi.one.example/healthz"},
+		},
+		{
+			name: "custom",
+			check: conf.HealthCheck{
+				URL:            "http://{{.Name}}.internal:8080/ready",
+				Method:         "head",
+				ExpectedStatus: []int{200, 204},
+				BodyMatch:      "^ok$",
+				Timeout:        5 * time.Second,
+				Interval:       time.Minute,
+			},
+			want: proxy.HealthCheck{
+				URL:            "http://one.internal:8080/ready",
+				Method:         "HEAD",
+				ExpectedStatus: []int{200, 204},
+				Timeout:        5 * time.Second,
+				Interval:       time.Minute,
+			},
+			wantMatch: "^ok$",
+		},
+		{name: "invalid URL template", check: conf.HealthCheck{URL: "https://{{.Cluster}}/healthz"}, wantErr: true},
+		{name: "invalid body match", check: conf.HealthCheck{BodyMatch: "(ok"}, wantErr: true},
+		{name: "negative timeout", check: conf.HealthCheck{Timeout: -time.Second}, wantErr: true},
+		{name: "negative interval", check: conf.HealthCheck{Interval: -time.Second}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := healthCheck(conf.Installation{Name: "one", Domain: "one.example", Check: tt.check})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("healthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var match string
+			if got.BodyMatch != nil {
+				match = got.BodyMatch.String()
+			}
+			if match != tt.wantMatch {
+				t.Errorf("BodyMatch = %q, want %q", match, tt.wantMatch)
+			}
+			got.BodyMatch = nil
+			if got.URL != tt.want.URL || got.Method != tt.want.Method || !slices.Equal(got.ExpectedStatus, tt.want.ExpectedStatus) ||
+				got.Timeout != tt.want.Timeout || got.Interval != tt.want.Interval {
+				t.Errorf("healthCheck() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderTemplate(t *testing.T) {
+	inst := conf.Installation{Name: "one", Domain: "one.example"}
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: "ins={{.Name}}", want: "ins=one"},
+		{text: "https://api.{{.Domain}}/healthz", want: "https://api.one.example/healthz"},
+		{text: "no template", want: "no template"},
+		{text: "{{.Unknown}}", wantErr: true},
+		{text: "{{.Name", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := renderTemplate(tt.text, inst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tshBackend(t *testing.T) {
+	// A tsh that records its arguments and knows one node
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\nif [ \"$1\" = ls ]; then echo node-a; fi\n"
+	err := os.WriteFile(filepath.Join(dir, "tsh"), []byte(script), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	tests := []struct {
+		name    string
+		inst    conf.Installation
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			inst: conf.Installation{Name: "one", Domain: "one.example"},
+			want: []string{
+				"ls --format=names ins=one,cluster=one,role=control-plane",
+				"ssh --no-remote-exec --dynamic-forward 1081 root@node=node-a,ins=one,cluster=one,role=control-plane",
+			},
+		},
+		{
+			name: "custom",
+			inst: conf.Installation{Name: "one", Domain: "one.example", Selector: "env={{.Domain}}", Query: `labels.tier == "a"`, Login: "admin"},
+			want: []string{
+				`ls --format=names --query labels.tier == "a" env=one.example`,
+				"ssh --no-remote-exec --dynamic-forward 1081 admin@node=node-a,env=one.example",
+			},
+		},
+		{
+			name:    "invalid selector",
+			inst:    conf.Installation{Name: "one", Selector: "ins={{.Cluster}}"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown profile",
+			inst:    conf.Installation{Name: "one", Teleport: "other"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove(calls)
+			savedConfig := config
+			t.Cleanup(func() { config = savedConfig })
+			config = conf.Config{}
+
+			backend, err := tshBackend(tt.inst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tshBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			nodes, err := backend.Nodes()
+			if err != nil || !slices.Equal(nodes, []string{"node-a"}) {
+				t.Fatalf("Nodes() = %v, %v, want node-a", nodes, err)
+			}
+			tunnel, err := backend.Open("node-a", 1081)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			_ = tunnel.Wait()
+
+			got, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := strings.Split(strings.TrimSpace(string(got)), "\n"); !slices.Equal(lines, tt.want) {
+				t.Errorf("tsh was run with %q, want %q", lines, tt.want)
+			}
+		})
+	}
+}
+
+// fakeBackend is a TunnelBackend with tunnels that run until closed.
+type fakeBackend struct {
+	nodes []string
+}
+
+func (b *fakeBackend) Nodes() ([]string, error) {
+	return b.nodes, nil
+}
+
+func (b *fakeBackend) Open(node string, port int) (proxy.Tunnel, error) {
+	return &fakeTunnel{done: make(chan struct{})}, nil
+}
+
+type fakeTunnel struct {
+	done chan struct{}
+}
+
+func (t *fakeTunnel) Wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *fakeTunnel) Close() error {
+	close(t.done)
+	return nil
+}
+
+func Test_reloadConfig(t *testing.T) {
+	savedConfig, savedLogger := config, logger
+	t.Cleanup(func() { config, logger = savedConfig, savedLogger })
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	const settings = `
+teleport:
+  check_interval: 1m
+log:
+  level: info
+  format: text
+`
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name: "check and hosts changed",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+    patterns: ["*.one.internal"]
+    check:
+      url: http://api.{{.Domain}}/ready
+      method: head
+  - name: two
+    domain: two.example
+`,
+		},
+		{
+			name: "installation added",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+  - name: two
+    domain: two.example
+  - name: three
+    domain: three.example
+`,
+			wantErr: true,
+		},
+		{
+			name: "installation removed",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+`,
+			wantErr: true,
+		},
+		{
+			name: "installation replaced",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+  - name: three
+    domain: three.example
+`,
+			wantErr: true,
+		},
+		{
+			name: "domain changed",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+  - name: two
+    domain: other.example
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid check",
+			config: `
+installations:
+  - name: one
+    domain: one.example
+    check:
+      body_match: "(ok"
+  - name: two
+    domain: two.example
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			path := filepath.Join(t.TempDir(), "linkmeup.yaml")
+			err := os.WriteFile(path, []byte(tt.config+settings), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			viper.SetConfigFile(path)
+
+			config = conf.Config{Installations: []conf.Installation{{Name: "one", Domain: "one.example"}, {Name: "two", Domain: "two.example"}}}
+			var proxies []*proxy.Proxy
+			for _, inst := range config.Installations {
+				check, err := healthCheck(inst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, err := proxy.New(logger, inst.Name, inst.Domain, check, &fakeBackend{nodes: []string{"node-a"}})
+				if err != nil {
+					t.Fatalf("proxy.New() error = %v", err)
+				}
+				t.Cleanup(func() { _ = p.Stop() })
+				proxies = append(proxies, p)
+			}
+
+			err = reloadConfig(proxies)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reloadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Nothing is applied if the config can't be
+			wantURL, wantMethod, wantMatch := "https://happaapi.one.example/healthz", "GET", false
+			if !tt.wantErr {
+				wantURL, wantMethod, wantMatch = "http://api.one.example/ready", "HEAD", true
+			}
+			check := proxies[0].Check
+			if check.URL != wantURL || check.Method != wantMethod {
+				t.Errorf("check of one = %s %s, want %s %s", check.Method, check.URL, wantMethod, wantURL)
+			}
+			if got := proxies[0].Matches("api.one.internal"); got != wantMatch {
+				t.Errorf("Matches(api.one.internal) = %v, want %v", got, wantMatch)
+			}
+			if got := proxies[1].Check.URL; got != "https://happaapi.two.example/healthz" {
+				t.Errorf("check URL of two = %s, want the default", got)
+			}
+
+			// The reloaded config is the one in use from now on
+			wantConfig := ""
+			if !tt.wantErr {
+				wantConfig = "http://api.{{.Domain}}/ready"
+			}
+			if got := config.Installations[0].Check.URL; got != wantConfig {
+				t.Errorf("check URL of one in config = %q, want %q", got, wantConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Runs the proxies without the terminal UI",
+	Long: `Runs the proxies, the local proxy servers and the PAC server like linkmeup
+does, but without the terminal UI and logging to stdout instead. No terminal
+is needed, which suits systemd user units, tmux or containers.
+
+Use 'linkmeup ctl' to control it while it runs.`,
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		initConfig()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		headless = true
+		return runRootCommand(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/giantswarm/linkmeup/pkg/conf"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+	"github.com/giantswarm/linkmeup/pkg/tui"
+)
+
+// Runs tsh for everything linkmeup does with Teleport. Tests replace it with
+// a fake.
+var tshRunner tshexec.Runner = tshexec.Default
+
+// Returns the Teleport profiles of the config, starting with the default
+// one made of the proxy, auth and cluster set in teleport directly. The
+// default profile has no name, and no proxy unless one is set, in which
+// case tsh uses the active profile.
+func teleportProfiles(c conf.Config) []conf.TeleportProfile {
+	t := c.Teleport
+	return append([]conf.TeleportProfile{{Proxy: t.Proxy, Auth: t.Auth, Cluster: t.Cluster}}, t.Profiles...)
+}
+
+// Returns the Teleport profile an installation is reached through.
+func teleportProfile(c conf.Config, inst conf.Installation) (conf.TeleportProfile, error) {
+	for _, p := range teleportProfiles(c) {
+		if p.Name == inst.Teleport {
+			return p, nil
+		}
+	}
+	return conf.TeleportProfile{}, fmt.Errorf("unknown Teleport profile %q", inst.Teleport)
+}
+
+// Returns the Teleport profiles at least one installation is reached
+// through.
+func usedProfiles(c conf.Config) []conf.TeleportProfile {
+	var used []conf.TeleportProfile
+	for _, p := range teleportProfiles(c) {
+		if slices.ContainsFunc(c.Installations, func(inst conf.Installation) bool { return inst.Teleport == p.Name }) {
+			used = append(used, p)
+		}
+	}
+	return used
+}
+
+// Checks the Teleport profiles and the references of the installations to
+// them.
+func validateProfiles(c conf.Config) error {
+	names := make(map[string]bool, len(c.Teleport.Profiles))
+	for _, p := range c.Teleport.Profiles {
+		if p.Name == "" {
+			return fmt.Errorf("every entry of teleport.profiles needs a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("teleport.profiles contains %s more than once", p.Name)
+		}
+		names[p.Name] = true
+		if p.Proxy == "" {
+			return fmt.Errorf("entry %s of teleport.profiles needs a proxy", p.Name)
+		}
+	}
+
+	for _, inst := range c.Installations {
+		_, err := teleportProfile(c, inst)
+		if err != nil {
+			return fmt.Errorf("installation %s: %w", inst.Name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the command to log in to a Teleport profile, for display. Settings
+// that are not configured are shown as placeholders.
+func loginHint(profile conf.TeleportProfile) string {
+	cmd := tshstatus.NewLoginCommand(profile.Proxy, profile.Auth, profile.Cluster, nil)
+	if cmd.Proxy == "" {
+		cmd.Proxy = "PROXY"
+		if cmd.Auth == "" {
+			cmd.Auth = "AUTH"
+		}
+	}
+	return cmd.String()
+}
+
+// Returns the valid Teleport profile of the proxy from the result of
+// GetStatus.
+func profileStatus(status *tshstatus.Status, err error, proxy string) (*tshstatus.Profile, error) {
+	if err != nil {
+		return nil, err
+	}
+	return status.Profile(proxy)
+}
+
+// Checks that the user is logged in to all Teleport profiles used by the
+// installations. When running in a terminal, it offers to log in where
+// needed. Returns the status once logged in to all of them.
+func checkTeleport() (*tshstatus.Status, error) {
+	status, err := tshstatus.GetStatus(logger, tshRunner)
+
+	for _, profile := range usedProfiles(config) {
+		active, perr := profileStatus(status, err, profile.Proxy)
+		if tshstatus.NeedsLogin(perr) && profile.Proxy != "" && isTerminal(os.Stdin) {
+			cmd := tshstatus.NewLoginCommand(profile.Proxy, profile.Auth, profile.Cluster, perr)
+			cmd.Runner = tshRunner
+			if confirm(os.Stdin, fmt.Sprintf("You need to log in to Teleport at %s (%s). Run '%s' now?", profile.Proxy, perr, cmd)) {
+				err = cmd.Run()
+				if err != nil {
+					return nil, err
+				}
+				status, err = tshstatus.GetStatus(logger, tshRunner)
+				active, perr = profileStatus(status, err, profile.Proxy)
+			}
+		}
+
+		at := ""
+		if profile.Proxy != "" {
+			at = " at " + profile.Proxy
+		}
+		switch {
+		case errors.Is(perr, tshstatus.ErrNoValidKeyPair):
+			fmt.Printf("Error: Your Teleport key pair is not valid. Please log out using 'tsh logout' and then log in using '%s'.\n", loginHint(profile))
+			os.Exit(1)
+		case tshstatus.NeedsLogin(perr):
+			fmt.Printf("Error: You are not logged in to Teleport%s. Please log in using '%s'.\n", at, loginHint(profile))
+			os.Exit(1)
+		case perr != nil:
+			if r := tsherr.Remediation(perr); r != "" {
+				return nil, fmt.Errorf("failed to get tsh status: %w, %s", perr, r)
+			}
+			return nil, fmt.Errorf("failed to get tsh status: %w", perr)
+		}
+
+		logger.Debug("Teleport profile found", slog.String("proxy", active.ProxyHost()), slog.String("cluster", active.Cluster), slog.Time("valid_until", active.ValidUntil))
+	}
+
+	return status, nil
+}
+
+// Asks the user a yes or no question on the terminal, yes by default, and
+// reads the answer from in. No answer at all, like at the end of the input,
+// counts as no.
+func confirm(in io.Reader, question string) bool {
+	fmt.Printf("%s [Y/n] ", question)
+
+	answer, err := readLine(in)
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// Reads a line from r without reading ahead, which would take input meant
+// for the next question or for tsh from it.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// Ensures that the SSH login of every installation is allowed for the
+// Teleport user of its profile.
+func validateLogins(status *tshstatus.Status) error {
+	for _, inst := range config.Installations {
+		profile, err := teleportProfile(config, inst)
+		if err != nil {
+			return err
+		}
+		active, err := status.Profile(profile.Proxy)
+		if err != nil {
+			return err
+		}
+
+		l := login(inst)
+		if !slices.Contains(active.Logins, l) {
+			return fmt.Errorf("SSH login %q configured for %s is not allowed for your Teleport user, available logins: %s", l, inst.Name, strings.Join(active.Logins, ", "))
+		}
+	}
+	return nil
+}
+
+// Returns the name a Teleport profile is reported by. The default profile
+// has no name in the config, so it goes by its proxy if it has one.
+func profileName(profile conf.TeleportProfile) string {
+	switch {
+	case profile.Name != "":
+		return profile.Name
+	case profile.Proxy != "":
+		return profile.Proxy
+	default:
+		return "default"
+	}
+}
+
+// Watches the session of each Teleport profile used by the installations
+// until ctx is cancelled. Without a session no tunnel can work, so the
+// proxies of a profile whose session expired wait for a new login instead
+// of cycling through the nodes. onSession is called with the name and
+// session of each profile right away, and again with the whole status after
+// each new login to it. Returns the sessions for display in the TUI.
+func watchSessions(ctx context.Context, status *tshstatus.Status, proxies []*proxy.Proxy, onSession func(name string, profile *tshstatus.Profile, status *tshstatus.Status)) ([]tui.Session, error) {
+	var sessions []tui.Session
+	for _, profile := range usedProfiles(config) {
+		w, err := tshstatus.NewWatcher(logger, status, profile.Proxy, config.Teleport.CheckInterval, config.Teleport.WarnBefore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch Teleport session: %w", err)
+		}
+		name := profileName(profile)
+		// Checked by NewWatcher already
+		active, _ := status.Profile(profile.Proxy)
+		onSession(name, active, status)
+
+		// Proxies are started in the order of the installations
+		var affected []*proxy.Proxy
+		for i, inst := range config.Installations {
+			if inst.Teleport == profile.Name {
+				affected = append(affected, proxies[i])
+			}
+		}
+
+		hint := loginHint(profile)
+		w.Runner = tshRunner
+		w.OnExpired = func(reason string) {
+			for _, p := range affected {
+				err := p.ExpireAuth(fmt.Sprintf("Teleport session ended (%s), log in using '%s'", reason, hint))
+				if err != nil {
+					logger.Warn("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+				}
+			}
+		}
+		w.OnLogin = func(status *tshstatus.Status) {
+			active, err := status.Profile(profile.Proxy)
+			if err == nil {
+				onSession(name, active, status)
+			}
+			for _, p := range affected {
+				err := p.Resume()
+				if err != nil {
+					logger.Warn("Failed to resume proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+				}
+			}
+		}
+		go w.Run(ctx)
+
+		session := tui.Session{Name: name, Watcher: w}
+		if profile.Proxy != "" {
+			session.Login = &tshstatus.LoginCommand{Proxy: profile.Proxy, Auth: profile.Auth, Cluster: profile.Cluster, Runner: tshRunner}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
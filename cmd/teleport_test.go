@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/conf"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+func Test_validateLogins(t *testing.T) {
+	status := &tshstatus.Status{
+		Active: &tshstatus.Profile{
+			ProfileURL: "https://teleport.example.com:443",
+			Logins:     []string{"root", "admin"},
+			ValidUntil: time.Now().Add(time.Hour),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		inst    conf.Installation
+		wantErr bool
+	}{
+		{name: "default login", inst: conf.Installation{Name: "one"}},
+		{name: "allowed login", inst: conf.Installation{Name: "one", Login: "admin"}},
+		{name: "login not allowed", inst: conf.Installation{Name: "one", Login: "ubuntu"}, wantErr: true},
+		{name: "unknown profile", inst: conf.Installation{Name: "one", Teleport: "other"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedConfig := config
+			t.Cleanup(func() { config = savedConfig })
+			config = conf.Config{
+				Installations: []conf.Installation{tt.inst},
+				Teleport:      conf.Teleport{Proxy: "teleport.example.com"},
+			}
+
+			err := validateLogins(status)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLogins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_confirm(t *testing.T) {
+	// Answers typed ahead, and input for tsh after them
+	in := strings.NewReader("n\n\nYes\nfor tsh")
+	for i, want := range []bool{false, true, true} {
+		if got := confirm(in, "Continue?"); got != want {
+			t.Errorf("confirm() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+
+	rest, _ := io.ReadAll(in)
+	if string(rest) != "for tsh" {
+		t.Errorf("input left = %q, want the input for tsh", rest)
+	}
+	if confirm(in, "Continue?") {
+		t.Error("confirm() at the end of the input = true")
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
-import "github.com/giantswarm/linkmeup/cmd"
+import (
+	"os"
+
+	"github.com/giantswarm/linkmeup/cmd"
+)
 
 func main() {
 	err := cmd.Execute()
 	if err != nil {
-		panic(err)
+		// Cobra has printed the error already
+		os.Exit(1)
 	}
 }
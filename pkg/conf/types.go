@@ -1,8 +1,13 @@
 package conf
 
+import "time"
+
 type Config struct {
 	Installations []Installation `mapstructure:"installations"`
 	Teleport      Teleport       `mapstructure:"teleport"`
+	Proxy         Proxy          `mapstructure:"proxy"`
+	PAC           PAC            `mapstructure:"pac"`
+	Log           Log            `mapstructure:"log"`
 }
 
 // Settings for a Giant Swarm installation
@@ -11,6 +16,99 @@ type Installation struct {
 	Name string `mapstructure:"name"`
 	// The base domain associated with the installation
 	Domain string `mapstructure:"domain"`
+	// Additional domains to use the proxy for, including subdomains
+	Domains []string `mapstructure:"domains"`
+	// Host name patterns to use the proxy for, with * and ? wildcards
+	Patterns []string `mapstructure:"patterns"`
+	// Host name patterns that must not use the proxy, like public endpoints
+	// under the base domain
+	Exclude []string `mapstructure:"exclude"`
+	// IP ranges in CIDR notation to use the proxy for
+	Networks []string `mapstructure:"networks"`
+	// How to check that the installation is reachable
+	Check HealthCheck `mapstructure:"check"`
+	// Teleport label selector for the nodes to tunnel through. Can be a
+	// template using {{.Name}} and {{.Domain}} of the installation.
+	// Defaults to ins={{.Name}},cluster={{.Name}},role=control-plane
+	Selector string `mapstructure:"selector"`
+	// Optional Teleport predicate expression to further filter the nodes,
+	// as passed to `tsh ls --query`
+	Query string `mapstructure:"query"`
+	// SSH login to use for the tunnel (default root)
+	Login string `mapstructure:"login"`
+	// Name of the Teleport profile in teleport.profiles the installation
+	// is reached through. Uses the proxy and cluster set in teleport
+	// directly if empty.
+	Teleport string `mapstructure:"teleport"`
+}
+
+// Settings for the health check of an installation
+type HealthCheck struct {
+	// URL to request. Can be a template using {{.Name}} and {{.Domain}} of
+	// the installation. Defaults to https://happaapi.{{.Domain}}/healthz
+	URL string `mapstructure:"url"`
+	// HTTP method to use (default GET)
+	Method string `mapstructure:"method"`
+	// Status codes considered healthy. By default, any code below 500 is.
+	ExpectedStatus []int `mapstructure:"expected_status"`
+	// Regular expression the response body must match, if set
+	BodyMatch string `mapstructure:"body_match"`
+	// Timeout for a single check (default 10s)
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Time between checks (default 30s)
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Settings for the local proxy clients connect to
+type Proxy struct {
+	// Port of the SOCKS5 proxy on localhost (default 1080)
+	SOCKS5Port int `mapstructure:"socks5_port"`
+	// Port of the HTTP proxy on localhost, for clients that cannot speak
+	// SOCKS5. Disabled if 0.
+	HTTPPort int `mapstructure:"http_port"`
+}
+
+// Settings for the proxy auto-configuration (PAC) file
+type PAC struct {
+	// Port of the web server serving the PAC file (default 9999)
+	Port int `mapstructure:"port"`
+	// Address the web server listens on (default 127.0.0.1)
+	Address string `mapstructure:"address"`
+	// Paths the PAC file is served at (default /proxy.pac and /wpad.dat)
+	Paths []string `mapstructure:"paths"`
+	// Which local proxy the PAC file points clients to, "socks5" (default)
+	// or "http"
+	ProxyType string `mapstructure:"proxy_type"`
+	// How installations that are unhealthy or have no nodes appear in the
+	// PAC file: "proxy" (default) keeps them, "fallback" lets clients
+	// connect directly if the proxy fails, "omit" leaves them out
+	Unhealthy string `mapstructure:"unhealthy"`
+	// Existing PAC file, as URL or local path, to fall back to for hosts
+	// not handled by linkmeup instead of connecting directly
+	Upstream string `mapstructure:"upstream"`
+	// How often to reload the upstream PAC file (default 1h)
+	UpstreamRefresh time.Duration `mapstructure:"upstream_refresh"`
+	// Whether to export Prometheus metrics at /metrics on the web server
+	Metrics bool `mapstructure:"metrics"`
+	// Whether to serve the dashboard at /dashboard/ on the web server
+	// (default true)
+	Dashboard bool `mapstructure:"dashboard"`
+}
+
+// Settings for the log file
+type Log struct {
+	// Path of the log file (default $XDG_STATE_HOME/linkmeup/linkmeup.log).
+	// No log file is written if empty.
+	File string `mapstructure:"file"`
+	// Minimum level of the entries in the log file and the log pane of the
+	// TUI: debug, info (default), warn or error
+	Level string `mapstructure:"level"`
+	// Format of the log file, "text" (default) or "json"
+	Format string `mapstructure:"format"`
+	// Size in megabytes at which the log file is rotated (default 10)
+	MaxSize int `mapstructure:"max_size"`
+	// Number of rotated log files to keep (default 3)
+	MaxBackups int `mapstructure:"max_backups"`
 }
 
 // Configuration settings needed for Teleport
@@ -19,4 +117,26 @@ type Teleport struct {
 	Proxy string `mapstructure:"proxy"`
 	// The string passed to the `--auth` flag in `tsh login`
 	Auth string `mapstructure:"auth"`
+	// Teleport cluster the installations are in, if not the root cluster
+	// of the proxy
+	Cluster string `mapstructure:"cluster"`
+	// Further Teleport proxies and clusters installations can refer to
+	Profiles []TeleportProfile `mapstructure:"profiles"`
+	// How often to check whether the session is still valid (default 1m)
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	// How long before the session expires to warn about it (default 15m)
+	WarnBefore time.Duration `mapstructure:"warn_before"`
+}
+
+// A Teleport proxy and cluster installations are reached through
+type TeleportProfile struct {
+	// Name installations refer to the profile by
+	Name string `mapstructure:"name"`
+	// The string passed to the `--proxy` flag of tsh
+	Proxy string `mapstructure:"proxy"`
+	// The string passed to the `--auth` flag in `tsh login`
+	Auth string `mapstructure:"auth"`
+	// Teleport cluster the installations are in, if not the root cluster
+	// of the proxy
+	Cluster string `mapstructure:"cluster"`
 }
@@ -0,0 +1,138 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/dashboard"
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+)
+
+// Timeout for a single request to the control API. Restarts and reloads
+// open tunnels, which can take a while.
+const clientTimeout = 30 * time.Second
+
+// Client talks to the control API of a running linkmeup.
+type Client struct {
+	path string
+	http *http.Client
+}
+
+// NewClient creates a client for the control socket at path.
+func NewClient(path string) *Client {
+	return &Client{
+		path: path,
+		http: &http.Client{
+			Timeout: clientTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the status of linkmeup.
+func (c *Client) Status(ctx context.Context) (pacserver.Status, error) {
+	var status pacserver.Status
+
+	resp, err := c.do(ctx, http.MethodGet, "/status", nil)
+	if err != nil {
+		return status, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return status, fmt.Errorf("failed to decode status: %w", err)
+	}
+	return status, nil
+}
+
+// Restart reopens the tunnel of an installation to the same node.
+func (c *Client) Restart(ctx context.Context, name string) error {
+	return c.post(ctx, proxyPath(name, "restart"), nil)
+}
+
+// SwitchNode moves the tunnel of an installation to the given node, or to a
+// different one if node is empty.
+func (c *Client) SwitchNode(ctx context.Context, name, node string) error {
+	return c.post(ctx, proxyPath(name, "switch"), dashboard.SwitchRequest{Node: node})
+}
+
+// Enable starts the proxy of an installation that was disabled.
+func (c *Client) Enable(ctx context.Context, name string) error {
+	return c.post(ctx, proxyPath(name, "enable"), nil)
+}
+
+// Disable stops the proxy of an installation until it is enabled again.
+func (c *Client) Disable(ctx context.Context, name string) error {
+	return c.post(ctx, proxyPath(name, "disable"), nil)
+}
+
+// Reload makes linkmeup reload its configuration file.
+func (c *Client) Reload(ctx context.Context) error {
+	return c.post(ctx, "/reload", nil)
+}
+
+// Shutdown stops linkmeup.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.post(ctx, "/shutdown", nil)
+}
+
+// Returns the path of an action on the proxy of an installation.
+func proxyPath(name, action string) string {
+	return fmt.Sprintf("/proxies/%s/%s", url.PathEscape(name), action)
+}
+
+// Sends a POST request with body encoded as JSON, if not nil.
+func (c *Client) post(ctx context.Context, path string, body any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, path, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Sends a request and turns error responses into errors.
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	// The host is ignored, the connection always goes to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://linkmeup"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to linkmeup at %s, is it running? %w", c.path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,173 @@
+package control
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// fakeBackend is a TunnelBackend with tunnels that run until closed.
+type fakeBackend struct {
+	nodes []string
+}
+
+func (b *fakeBackend) Nodes() ([]string, error) {
+	return b.nodes, nil
+}
+
+func (b *fakeBackend) Open(node string, port int) (proxy.Tunnel, error) {
+	return &fakeTunnel{done: make(chan struct{})}, nil
+}
+
+type fakeTunnel struct {
+	done chan struct{}
+}
+
+func (t *fakeTunnel) Wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *fakeTunnel) Close() error {
+	close(t.done)
+	return nil
+}
+
+// Starts a control server for one proxy and returns a client for it. The
+// server can be configured before it starts by passing configure.
+func testServer(t *testing.T, configure func(*Server)) (*Server, *Client, *proxy.Proxy) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p, err := proxy.New(logger, "test-installation", "example.com", proxy.HealthCheck{URL: "https://example.com/healthz"}, &fakeBackend{nodes: []string{"node-a", "node-b"}})
+	if err != nil {
+		t.Fatalf("proxy.New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+
+	proxies := []*proxy.Proxy{p}
+	pac, err := pacserver.New(logger, proxies, 9999, pacserver.ProxyTypeSOCKS5, 1080, pacserver.UnhealthyProxy)
+	if err != nil {
+		t.Fatalf("pacserver.New() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "linkmeup.sock")
+	s, err := NewServer(logger, path, proxies, pac)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if configure != nil {
+		configure(s)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	err = s.Serve(ctx)
+	if err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	return s, NewClient(path), p
+}
+
+func TestClient_Status(t *testing.T) {
+	_, c, _ := testServer(t, nil)
+
+	status, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(status.Proxies) != 1 || status.Proxies[0].Name != "test-installation" || len(status.Proxies[0].Nodes) != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestClient_proxyActions(t *testing.T) {
+	_, c, p := testServer(t, nil)
+	ctx := context.Background()
+
+	err := c.SwitchNode(ctx, "test-installation", "node-b")
+	if err != nil {
+		t.Fatalf("SwitchNode() error = %v", err)
+	}
+	if got := p.Status().ActiveNode; got != "node-b" {
+		t.Errorf("ActiveNode = %q, want node-b", got)
+	}
+
+	err = c.SwitchNode(ctx, "test-installation", "node-c")
+	if err == nil || !strings.Contains(err.Error(), "unknown node") {
+		t.Errorf("SwitchNode() to unknown node error = %v", err)
+	}
+
+	err = c.Disable(ctx, "test-installation")
+	if err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if got := p.Status().State; got != proxy.StateStopped {
+		t.Errorf("State after Disable() = %v, want %v", got, proxy.StateStopped)
+	}
+	if err := c.Restart(ctx, "test-installation"); err == nil {
+		t.Error("Restart() of disabled proxy should fail")
+	}
+
+	err = c.Enable(ctx, "test-installation")
+	if err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if got := p.Status().State; got != proxy.StateConnecting {
+		t.Errorf("State after Enable() = %v, want %v", got, proxy.StateConnecting)
+	}
+
+	err = c.Restart(ctx, "unknown")
+	if err == nil || !strings.Contains(err.Error(), "unknown installation") {
+		t.Errorf("Restart() of unknown installation error = %v", err)
+	}
+}
+
+func TestClient_ReloadShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	_, c, _ := testServer(t, nil)
+	err := c.Reload(ctx)
+	if err == nil {
+		t.Error("Reload() without hook should fail")
+	}
+
+	var reloaded, shutdown atomic.Bool
+	_, c, _ = testServer(t, func(s *Server) {
+		s.Reload = func() error {
+			reloaded.Store(true)
+			return nil
+		}
+		s.Shutdown = func() {
+			shutdown.Store(true)
+		}
+	})
+
+	err = c.Reload(ctx)
+	if err != nil || !reloaded.Load() {
+		t.Errorf("Reload() error = %v, reloaded = %v", err, reloaded.Load())
+	}
+	err = c.Shutdown(ctx)
+	if err != nil || !shutdown.Load() {
+		t.Errorf("Shutdown() error = %v, shut down = %v", err, shutdown.Load())
+	}
+}
+
+func TestServer_Serve_inUse(t *testing.T) {
+	s, _, _ := testServer(t, nil)
+
+	other, err := NewServer(s.logger, s.path, s.proxies, s.pac)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	err = other.Serve(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Serve() on a socket in use error = %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package control lets other processes control a running linkmeup through
+// an HTTP API on a Unix domain socket. Server serves the API, Client talks
+// to it, as used by `linkmeup ctl`.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/dashboard"
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// How long running control requests may take to finish once ctx is
+// cancelled.
+const shutdownTimeout = 5 * time.Second
+
+// DefaultSocketPath returns the path of the control socket, in
+// $XDG_RUNTIME_DIR if set and in the temporary directory otherwise.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "linkmeup.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("linkmeup-%d.sock", os.Getuid()))
+}
+
+// Server serves the control API on a Unix domain socket.
+type Server struct {
+	logger  *slog.Logger
+	path    string
+	proxies []*proxy.Proxy
+	pac     *pacserver.PacServer
+	server  *http.Server
+
+	// Reloads the configuration, if set
+	Reload func() error
+	// Shuts linkmeup down, if set
+	Shutdown func()
+}
+
+// NewServer creates a control server listening on the socket at path. The
+// status is taken from the PAC server.
+func NewServer(logger *slog.Logger, path string, proxies []*proxy.Proxy, pac *pacserver.PacServer) (*Server, error) {
+	if path == "" {
+		return nil, fmt.Errorf("socket path cannot be empty")
+	}
+	if pac == nil {
+		return nil, fmt.Errorf("PAC server cannot be nil")
+	}
+
+	return &Server{
+		logger:  logger,
+		path:    path,
+		proxies: proxies,
+		pac:     pac,
+	}, nil
+}
+
+// Serve starts serving the API in the background. A socket left behind by
+// a linkmeup that is no longer running is replaced. When ctx is cancelled,
+// the server shuts down and removes the socket.
+func (s *Server) Serve(ctx context.Context) error {
+	if _, err := os.Stat(s.path); err == nil {
+		conn, err := net.Dial("unix", s.path)
+		if err == nil {
+			_ = conn.Close()
+			return fmt.Errorf("control socket %s is in use, is linkmeup running already?", s.path)
+		}
+		err = os.Remove(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+	// Only the user running linkmeup may control it
+	err = os.Chmod(s.path, 0o600)
+	if err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to restrict access to control socket: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /proxies/{name}/restart", s.handleProxy(func(p *proxy.Proxy, r *http.Request) error {
+		return p.Restart()
+	}))
+	mux.HandleFunc("POST /proxies/{name}/switch", s.handleProxy(switchNode))
+	mux.HandleFunc("POST /proxies/{name}/enable", s.handleProxy(enable))
+	mux.HandleFunc("POST /proxies/{name}/disable", s.handleProxy(func(p *proxy.Proxy, r *http.Request) error {
+		return p.Stop()
+	}))
+	mux.HandleFunc("POST /reload", s.handleReload)
+	mux.HandleFunc("POST /shutdown", s.handleShutdown)
+
+	s.server = &http.Server{
+		Handler:     mux,
+		ReadTimeout: 5 * time.Second,
+		IdleTimeout: 5 * time.Second,
+	}
+
+	s.logger.Debug("Serving control API", slog.String("socket", s.path))
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Control server error", slog.String("error", err.Error()))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		// This closes the listener, which removes the socket
+		err := s.server.Shutdown(shutdownCtx)
+		if err != nil {
+			s.logger.Error("Failed to shut down control server", slog.String("error", err.Error()))
+		}
+	}()
+
+	return nil
+}
+
+// Serves the status of linkmeup as JSON.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(s.pac.Status())
+	if err != nil {
+		s.logger.Debug("Failed to write status response", slog.String("error", err.Error()))
+	}
+}
+
+// Returns a handler running action on the proxy named in the path.
+func (s *Server) handleProxy(action func(p *proxy.Proxy, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		for _, p := range s.proxies {
+			if p.Name != name {
+				continue
+			}
+
+			s.logger.Info("Control request", slog.String("name", name), slog.String("path", r.URL.Path))
+			dashboard.Respond(w, action(p, r))
+			return
+		}
+
+		http.Error(w, fmt.Sprintf("unknown installation %q", name), http.StatusNotFound)
+	}
+}
+
+// Moves the tunnel of a proxy to the node in the request body.
+func switchNode(p *proxy.Proxy, r *http.Request) error {
+	var req dashboard.SwitchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", dashboard.ErrBadRequest, err)
+	}
+	return p.SwitchNode(req.Node)
+}
+
+// Starts a proxy that was disabled. Proxies running already are left alone.
+func enable(p *proxy.Proxy, r *http.Request) error {
+	if p.Status().State != proxy.StateStopped {
+		return nil
+	}
+	return p.Start()
+}
+
+// Reloads the configuration.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.Reload == nil {
+		http.Error(w, "reloading is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	s.logger.Info("Reloading configuration on control request")
+	dashboard.Respond(w, s.Reload())
+}
+
+// Shuts linkmeup down after responding.
+func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if s.Shutdown == nil {
+		http.Error(w, "shutting down is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	s.logger.Info("Shutting down on control request")
+	w.WriteHeader(http.StatusNoContent)
+	_ = http.NewResponseController(w).Flush()
+	s.Shutdown()
+}
@@ -0,0 +1,358 @@
+// Package dashboard serves an HTML page on the local web server showing the
+// state of the proxies, their recent health checks and the PAC file. The
+// page is updated live via server-sent events and offers buttons to restart
+// a proxy or switch its node.
+package dashboard
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// Number of health check results kept per proxy.
+const historySize = 30
+
+// Path is where the dashboard is served.
+const Path = "/dashboard/"
+
+//go:embed dashboard.html
+var page []byte
+
+// Ping is the result of one health check.
+type Ping struct {
+	Time       time.Time `json:"time"`
+	Success    bool      `json:"success"`
+	StatusCode int       `json:"status_code,omitempty"`
+	// Duration of the check in milliseconds
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Snapshot is the data the page is rendered from, sent on every change.
+type Snapshot struct {
+	pacserver.Status
+	// Recent health checks by installation name, oldest first
+	Pings map[string][]Ping `json:"pings"`
+	// Current PAC file
+	PAC string `json:"pac"`
+}
+
+// ErrBadRequest means that a request for a proxy action was invalid.
+var ErrBadRequest = errors.New("invalid request")
+
+// SwitchRequest is the request body for switching the node of a proxy, on
+// the dashboard and in the control API.
+type SwitchRequest struct {
+	// Node to switch to, a random different one if empty
+	Node string `json:"node"`
+}
+
+// Dashboard serves the page and keeps the health check history shown on it.
+type Dashboard struct {
+	logger  *slog.Logger
+	proxies []*proxy.Proxy
+	pac     *pacserver.PacServer
+
+	// Guards pings, clients and closed
+	mu    sync.Mutex
+	pings map[string][]Ping
+	// Channels of the connected pages, notified on every change
+	clients map[chan struct{}]struct{}
+	// Whether Run has ended
+	closed bool
+}
+
+// New creates a dashboard for the given proxies. The status and PAC file
+// are taken from the PAC server.
+func New(logger *slog.Logger, proxies []*proxy.Proxy, pac *pacserver.PacServer) *Dashboard {
+	return &Dashboard{
+		logger:  logger,
+		proxies: proxies,
+		pac:     pac,
+		pings:   map[string][]Ping{},
+		clients: map[chan struct{}]struct{}{},
+	}
+}
+
+// Handler returns the HTTP handler serving the dashboard and its actions
+// below Path.
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Path+"{$}", d.handlePage)
+	mux.HandleFunc("GET "+Path+"events", d.handleEvents)
+	mux.HandleFunc("POST "+Path+"proxies/{name}/restart", d.handleRestart)
+	mux.HandleFunc("POST "+Path+"proxies/{name}/switch", d.handleSwitch)
+	return mux
+}
+
+// Run records the health checks of the proxies and notifies the connected
+// pages of changes until ctx is cancelled. Open event streams are closed
+// then.
+func (d *Dashboard) Run(ctx context.Context) {
+	events, unsubscribe := proxy.SubscribeAll(d.proxies)
+	defer unsubscribe()
+	defer d.close()
+
+	for {
+		select {
+		case e := <-events:
+			if ping, ok := e.(proxy.PingResultEvent); ok {
+				d.record(ping)
+			}
+			d.notify()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Adds a health check result to the history of its proxy.
+func (d *Dashboard) record(e proxy.PingResultEvent) {
+	ping := Ping{
+		Time:       e.Time,
+		Success:    e.Success,
+		StatusCode: e.StatusCode,
+		Duration:   float64(e.Duration) / float64(time.Millisecond),
+	}
+	if e.Err != nil {
+		ping.Error = e.Err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	history := append(d.pings[e.Proxy], ping)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	d.pings[e.Proxy] = history
+}
+
+// Snapshot returns the current state shown on the page.
+func (d *Dashboard) Snapshot() Snapshot {
+	s := Snapshot{
+		Status: d.pac.Status(),
+		Pings:  map[string][]Ping{},
+		PAC:    d.pac.Body(),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, history := range d.pings {
+		s.Pings[name] = append([]Ping(nil), history...)
+	}
+	return s
+}
+
+// Returns a channel that receives a value whenever something changed, and a
+// function to stop receiving. The channel is closed when Run ends.
+func (d *Dashboard) subscribe() (<-chan struct{}, func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if d.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	d.clients[ch] = struct{}{}
+
+	return ch, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.clients[ch]; ok {
+			delete(d.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// Notifies all pages of a change. Changes arriving while a page is still
+// busy with the previous one are coalesced.
+func (d *Dashboard) notify() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for ch := range d.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Closes the channels of all pages.
+func (d *Dashboard) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	for ch := range d.clients {
+		delete(d.clients, ch)
+		close(ch)
+	}
+}
+
+// Serves the HTML page.
+func (d *Dashboard) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(page)
+}
+
+// Streams a snapshot as server-sent event initially and after every change.
+func (d *Dashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream stays open, so the write timeout of the server must not
+	// apply
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		d.logger.Debug("Failed to clear write deadline for event stream", slog.String("error", err.Error()))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	updates, unsubscribe := d.subscribe()
+	defer unsubscribe()
+
+	for {
+		data, err := json.Marshal(d.Snapshot())
+		if err != nil {
+			d.logger.Error("Failed to encode dashboard snapshot", slog.String("error", err.Error()))
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Restarts the tunnel of a proxy.
+func (d *Dashboard) handleRestart(w http.ResponseWriter, r *http.Request) {
+	p, ok := d.action(w, r)
+	if !ok {
+		return
+	}
+
+	d.logger.Info("Proxy restart requested from dashboard", slog.String("name", p.Name))
+	Respond(w, p.Restart())
+}
+
+// Moves the tunnel of a proxy to another node.
+func (d *Dashboard) handleSwitch(w http.ResponseWriter, r *http.Request) {
+	p, ok := d.action(w, r)
+	if !ok {
+		return
+	}
+
+	var req SwitchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		Respond(w, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+
+	d.logger.Info("Node switch requested from dashboard", slog.String("name", p.Name), slog.String("node", req.Node))
+	Respond(w, p.SwitchNode(req.Node))
+}
+
+// Checks an action request and returns the proxy it is for. Requests must
+// be JSON, which browsers don't send cross-origin without asking first, and
+// be addressed to the web server by its own address, so other web sites
+// can't trigger actions, not even through DNS rebinding. Writes the error
+// response and returns false if the request is invalid.
+func (d *Dashboard) action(w http.ResponseWriter, r *http.Request) (*proxy.Proxy, bool) {
+	if !d.ownHost(r.Host) {
+		http.Error(w, fmt.Sprintf("unknown host %q", r.Host), http.StatusForbidden)
+		return nil, false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !d.ownHost(u.Host) {
+			http.Error(w, fmt.Sprintf("cross-origin request from %q", origin), http.StatusForbidden)
+			return nil, false
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	name := r.PathValue("name")
+	for _, p := range d.proxies {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("unknown installation %q", name), http.StatusNotFound)
+	return nil, false
+}
+
+// Returns whether hostport, from the Host or Origin header, is the address
+// of the web server the dashboard is served on. Host names other than
+// localhost are refused even if they resolve to that address, as they may
+// belong to another web site rebinding its name.
+func (d *Dashboard) ownHost(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, "80"
+	}
+	if port != strconv.Itoa(d.pac.Port) {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") || host == d.pac.Address {
+		return true
+	}
+
+	// Listening on all interfaces, so any address of them will do
+	listen := net.ParseIP(d.pac.Address)
+	return net.ParseIP(host) != nil && (d.pac.Address == "" || (listen != nil && listen.IsUnspecified()))
+}
+
+// Respond writes the response to a proxy action that returned err, on the
+// dashboard and in the control API.
+func Respond(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrBadRequest), errors.Is(err, proxy.ErrUnknownNode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, proxy.ErrStopped):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
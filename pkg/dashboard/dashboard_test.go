@@ -0,0 +1,198 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/pacserver"
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+func testDashboard(t *testing.T) *Dashboard {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	proxies := []*proxy.Proxy{{Name: "test-installation", Domain: "example.com", Port: 1081}}
+	pac, err := pacserver.New(logger, proxies, 9999, pacserver.ProxyTypeSOCKS5, 1080, pacserver.UnhealthyProxy)
+	if err != nil {
+		t.Fatalf("pacserver.New() error = %v", err)
+	}
+	return New(logger, proxies, pac)
+}
+
+func TestDashboard_record(t *testing.T) {
+	d := testDashboard(t)
+	for i := range historySize + 5 {
+		d.record(proxy.PingResultEvent{
+			EventInfo: proxy.EventInfo{Proxy: "test-installation", Time: time.Now()},
+			Success:   i%2 == 0,
+			Duration:  time.Duration(i) * time.Millisecond,
+			Err:       errors.New("timeout"),
+		})
+	}
+
+	s := d.Snapshot()
+	pings := s.Pings["test-installation"]
+	if len(pings) != historySize {
+		t.Fatalf("got %d pings, want %d", len(pings), historySize)
+	}
+	if pings[historySize-1].Duration != historySize+4 {
+		t.Errorf("last ping duration = %v ms, want %d ms", pings[historySize-1].Duration, historySize+4)
+	}
+	if !strings.Contains(s.PAC, "dnsDomainIs(host, 'example.com')") {
+		t.Errorf("snapshot PAC file does not contain the installation:\n%s", s.PAC)
+	}
+}
+
+func TestDashboard_actions(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		address     string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{
+			name:        "form posts are refused",
+			path:        "/dashboard/proxies/test-installation/restart",
+			contentType: "application/x-www-form-urlencoded",
+			want:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "unknown installation",
+			path:        "/dashboard/proxies/unknown/switch",
+			contentType: "application/json",
+			want:        http.StatusNotFound,
+		},
+		{
+			name:        "invalid body",
+			path:        "/dashboard/proxies/test-installation/switch",
+			contentType: "application/json; charset=utf-8",
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "localhost",
+			path:        "/dashboard/proxies/unknown/restart",
+			host:        "localhost:9999",
+			origin:      "http://localhost:9999",
+			contentType: "application/json",
+			want:        http.StatusNotFound,
+		},
+		{
+			name:        "other host name resolving to the server",
+			path:        "/dashboard/proxies/test-installation/restart",
+			host:        "rebind.example.com:9999",
+			contentType: "application/json",
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "other port",
+			path:        "/dashboard/proxies/test-installation/restart",
+			host:        "127.0.0.1:8080",
+			contentType: "application/json",
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "other origin",
+			path:        "/dashboard/proxies/test-installation/restart",
+			origin:      "https://attacker.example.com",
+			contentType: "application/json",
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "address of all interfaces",
+			path:        "/dashboard/proxies/unknown/restart",
+			address:     "0.0.0.0",
+			host:        "192.168.1.10:9999",
+			contentType: "application/json",
+			want:        http.StatusNotFound,
+		},
+		{
+			name:        "other address",
+			path:        "/dashboard/proxies/test-installation/restart",
+			host:        "192.168.1.10:9999",
+			contentType: "application/json",
+			want:        http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDashboard(t)
+			if tt.address != "" {
+				d.pac.Address = tt.address
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("node=a"))
+			req.Host = "127.0.0.1:9999"
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			d.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboard_handleEvents(t *testing.T) {
+	d := testDashboard(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/dashboard/events")
+	if err != nil {
+		t.Fatalf("GET events error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	var data string
+	for scanner.Scan() {
+		if after, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = after
+			break
+		}
+	}
+	var s Snapshot
+	err = json.Unmarshal([]byte(data), &s)
+	if err != nil {
+		t.Fatalf("failed to decode snapshot %q: %v", data, err)
+	}
+	if len(s.Proxies) != 1 || s.Proxies[0].Name != "test-installation" {
+		t.Errorf("unexpected proxies in snapshot %+v", s.Proxies)
+	}
+
+	// Cancelling ends the stream
+	cancel()
+	<-done
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		t.Errorf("stream ended with error %v", err)
+	}
+}
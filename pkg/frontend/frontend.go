@@ -0,0 +1,53 @@
+// Package frontend implements the local proxy server clients connect to.
+// It inspects the host name of each requested connection and forwards it
+// through the tunnel of the installation responsible for that host, so
+// clients only need a single proxy address no matter how many installations
+// are configured or how often their tunnels are restarted.
+package frontend
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// Returns the proxy responsible for host, or nil if there is none. If
+// several proxies match, the one with the most specific domain wins.
+func route(proxies []*proxy.Proxy, host string) *proxy.Proxy {
+	var match *proxy.Proxy
+	for _, p := range proxies {
+		if !p.Matches(host) {
+			continue
+		}
+		if match == nil || len(p.Domain) > len(match.Domain) {
+			match = p
+		}
+	}
+	return match
+}
+
+// Copies data between both connections until either side is done, then
+// closes both.
+func relay(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		_ = a.Close()
+		_ = b.Close()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(a, b)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(b, a)
+		once.Do(closeBoth)
+	}()
+	wg.Wait()
+}
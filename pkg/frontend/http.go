@@ -0,0 +1,196 @@
+package frontend
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// HTTPServer is an HTTP proxy for clients that cannot speak SOCKS5. HTTPS
+// connections are tunneled using the CONNECT method, plain HTTP requests are
+// forwarded. Like SOCKS5Server, it routes by host name and refuses hosts no
+// proxy is responsible for.
+type HTTPServer struct {
+	logger  *slog.Logger
+	proxies []*proxy.Proxy
+	server  *http.Server
+	// Forwards plain HTTP requests
+	forwarder *httputil.ReverseProxy
+
+	Port int
+}
+
+func NewHTTPServer(logger *slog.Logger, proxies []*proxy.Proxy, port int) (*HTTPServer, error) {
+	if proxies == nil {
+		return nil, fmt.Errorf("proxies cannot be nil")
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port number: %d", port)
+	}
+
+	s := &HTTPServer{
+		logger:  logger,
+		proxies: proxies,
+		Port:    port,
+	}
+	s.forwarder = &httputil.ReverseProxy{
+		// The request URL is absolute already, nothing to rewrite.
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			DialContext:           s.dial,
+			ResponseHeaderTimeout: dialTimeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Debug("HTTP proxy request failed", slog.String("url", r.URL.String()), slog.String("error", err.Error()))
+			http.Error(w, "Failed to connect through proxy", http.StatusBadGateway)
+		},
+	}
+
+	return s, nil
+}
+
+// Serve starts listening on localhost and handles requests in the
+// background until ctx is cancelled or Close is called.
+func (s *HTTPServer) Serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", s.Port, err)
+	}
+
+	s.logger.Info("Serving HTTP proxy", slog.String("address", listener.Addr().String()))
+	s.serve(listener)
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+
+	return nil
+}
+
+// Handles requests on listener in the background.
+func (s *HTTPServer) serve(listener net.Listener) {
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: handshakeTimeout,
+	}
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP proxy error", slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// Close stops the server and closes all connections.
+func (s *HTTPServer) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	s.forwarder.Transport.(*http.Transport).CloseIdleConnections()
+	return s.server.Close()
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	if host == "" {
+		http.Error(w, "This is a proxy server, requests must use an absolute URL or CONNECT", http.StatusBadRequest)
+		return
+	}
+
+	p := route(s.proxies, host)
+	if p == nil {
+		s.logger.Debug("HTTP proxy request refused", slog.String("host", host))
+		http.Error(w, fmt.Sprintf("No installation configured for host %s", host), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		s.connect(w, r, p)
+		return
+	}
+
+	s.forwarder.ServeHTTP(w, r)
+}
+
+// Tunnels the client connection to the requested address.
+func (s *HTTPServer) connect(w http.ResponseWriter, r *http.Request, p *proxy.Proxy) {
+	addr := r.URL.Host
+	if r.URL.Port() == "" {
+		addr = net.JoinHostPort(r.URL.Hostname(), "443")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dialTimeout)
+	upstream, err := p.Dial(ctx, "tcp", addr)
+	cancel()
+	if err != nil {
+		s.logger.Debug("HTTP CONNECT failed", slog.String("address", addr), slog.String("error", err.Error()))
+		http.Error(w, fmt.Sprintf("Failed to connect through proxy %s", p.Name), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "Connection hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		_ = conn.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	s.logger.Debug("HTTP CONNECT established", slog.String("address", addr))
+	relay(&bufferedConn{Conn: conn, r: buf.Reader}, upstream)
+}
+
+// Connects to addr through the tunnel of the proxy responsible for its host.
+func (s *HTTPServer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := route(s.proxies, host)
+	if p == nil {
+		return nil, fmt.Errorf("no installation configured for host %s", host)
+	}
+
+	return p.Dial(ctx, network, addr)
+}
+
+// bufferedConn is a connection whose first bytes may already have been read
+// into a buffer while parsing the CONNECT request.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// WriteTo makes io.Copy drain the buffer before reading from the connection.
+func (c *bufferedConn) WriteTo(w io.Writer) (int64, error) {
+	return c.r.WriteTo(w)
+}
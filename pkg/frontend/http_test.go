@@ -0,0 +1,121 @@
+package frontend
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	lproxy "github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+// Starts an HTTPServer for the given proxies and returns its address.
+func startHTTPServer(t *testing.T, proxies []*lproxy.Proxy) string {
+	t.Helper()
+	s, err := NewHTTPServer(testLogger(), proxies, 8080)
+	if err != nil {
+		t.Fatalf("NewHTTPServer() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serve(l)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return l.Addr().String()
+}
+
+func TestHTTPServer_connect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	addr := startHTTPServer(t, []*lproxy.Proxy{
+		{Name: "one", Domain: "one.example", Port: startFakeTunnel(t, echoAddr)},
+	})
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{name: "matching host", target: "happa.one.example:443", wantStatus: http.StatusOK},
+		{name: "unknown host", target: "example.org:443", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", tt.target, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatalf("ReadResponse() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			_, err = conn.Write([]byte("ping"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(reader, buf)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(buf) != "ping" {
+				t.Errorf("got %q, want %q", buf, "ping")
+			}
+		})
+	}
+}
+
+func TestHTTPServer_forward(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "hello from %s", r.Host)
+	}))
+	t.Cleanup(target.Close)
+
+	addr := startHTTPServer(t, []*lproxy.Proxy{
+		{Name: "one", Domain: "one.example", Port: startFakeTunnel(t, target.Listener.Addr().String())},
+	})
+	proxyURL, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get("http://happa.one.example/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(string(body), "happa.one.example") {
+		t.Errorf("body = %q, want request for happa.one.example", body)
+	}
+
+	resp, err = client.Get("http://example.org/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status for unknown host = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
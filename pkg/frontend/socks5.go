@@ -0,0 +1,250 @@
+package frontend
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5MethodNoAuth       = 0x00
+	socks5MethodNoAcceptable = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded          = 0x00
+	socks5ReplyGeneralFailure     = 0x01
+	socks5ReplyNotAllowed         = 0x02
+	socks5ReplyCmdNotSupported    = 0x07
+	socks5ReplyAddrTypeNotSupport = 0x08
+)
+
+var (
+	// Time a client has to complete the SOCKS5 handshake.
+	handshakeTimeout = 10 * time.Second
+	// Time allowed for connecting through a tunnel.
+	dialTimeout = 30 * time.Second
+)
+
+// Connects to addr on behalf of a client. host is the host part of addr.
+// The returned reply code is sent to the client if the error is not nil.
+type dialFunc func(ctx context.Context, host, addr string) (net.Conn, byte, error)
+
+// SOCKS5Server is a SOCKS5 proxy that forwards each connection through the
+// tunnel of the proxy responsible for the requested host. Connections to
+// hosts no proxy is responsible for are refused.
+type SOCKS5Server struct {
+	logger   *slog.Logger
+	proxies  []*proxy.Proxy
+	listener net.Listener
+
+	Port int
+}
+
+func NewSOCKS5Server(logger *slog.Logger, proxies []*proxy.Proxy, port int) (*SOCKS5Server, error) {
+	if proxies == nil {
+		return nil, fmt.Errorf("proxies cannot be nil")
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port number: %d", port)
+	}
+
+	return &SOCKS5Server{
+		logger:  logger,
+		proxies: proxies,
+		Port:    port,
+	}, nil
+}
+
+// Serve starts listening on localhost and handles connections in the
+// background until ctx is cancelled or Close is called.
+func (s *SOCKS5Server) Serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", s.Port, err)
+	}
+
+	s.logger.Info("Serving SOCKS5 proxy", slog.String("address", listener.Addr().String()))
+	s.serve(listener)
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+
+	return nil
+}
+
+// Accepts connections on listener in the background.
+func (s *SOCKS5Server) serve(listener net.Listener) {
+	s.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				s.logger.Error("SOCKS5 proxy failed to accept connection", slog.String("error", err.Error()))
+				continue
+			}
+			go handleSOCKS5(s.logger, conn, s.dial)
+		}
+	}()
+}
+
+// Close stops accepting new connections.
+func (s *SOCKS5Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *SOCKS5Server) dial(ctx context.Context, host, addr string) (net.Conn, byte, error) {
+	p := route(s.proxies, host)
+	if p == nil {
+		return nil, socks5ReplyNotAllowed, fmt.Errorf("no installation configured for host %s", host)
+	}
+
+	conn, err := p.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, socks5ReplyGeneralFailure, fmt.Errorf("failed to connect through proxy %s: %w", p.Name, err)
+	}
+
+	return conn, socks5ReplySucceeded, nil
+}
+
+// Handles a single SOCKS5 client connection as described in RFC 1928. Only
+// the CONNECT command without authentication is supported.
+func handleSOCKS5(logger *slog.Logger, conn net.Conn, dial dialFunc) {
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	host, addr, code, err := readSOCKS5Request(conn)
+	if err != nil {
+		logger.Debug("Invalid SOCKS5 request", slog.String("client", conn.RemoteAddr().String()), slog.String("error", err.Error()))
+		if code != socks5ReplySucceeded {
+			_ = writeSOCKS5Reply(conn, code)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	upstream, code, err := dial(ctx, host, addr)
+	cancel()
+	if err != nil {
+		logger.Debug("SOCKS5 connection failed", slog.String("address", addr), slog.String("error", err.Error()))
+		_ = writeSOCKS5Reply(conn, code)
+		return
+	}
+
+	err = writeSOCKS5Reply(conn, socks5ReplySucceeded)
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	logger.Debug("SOCKS5 connection established", slog.String("address", addr))
+	relay(conn, upstream)
+}
+
+// Performs method negotiation and reads the CONNECT request. Returns the
+// requested host and address. On failure, the returned code is the reply to
+// send to the client, or socks5ReplySucceeded if none should be sent.
+func readSOCKS5Request(conn net.Conn) (host string, addr string, code byte, err error) {
+	// Greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", "", socks5ReplySucceeded, err
+	}
+	if header[0] != socks5Version {
+		return "", "", socks5ReplySucceeded, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", "", socks5ReplySucceeded, err
+	}
+
+	noAuth := false
+	for _, m := range methods {
+		if m == socks5MethodNoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		_, _ = conn.Write([]byte{socks5Version, socks5MethodNoAcceptable})
+		return "", "", socks5ReplySucceeded, fmt.Errorf("client does not support authentication method 'none'")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5MethodNoAuth}); err != nil {
+		return "", "", socks5ReplySucceeded, err
+	}
+
+	// Request: version, command, reserved, address type
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", "", socks5ReplySucceeded, err
+	}
+	if request[0] != socks5Version {
+		return "", "", socks5ReplyGeneralFailure, fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	if request[1] != socks5CmdConnect {
+		return "", "", socks5ReplyCmdNotSupported, fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	switch request[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socks5AddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", "", socks5ReplySucceeded, err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", "", socks5ReplySucceeded, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", "", socks5ReplySucceeded, err
+		}
+		host = string(name)
+	default:
+		return "", "", socks5ReplyAddrTypeNotSupport, fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", "", socks5ReplySucceeded, err
+	}
+
+	addr = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	return host, addr, socks5ReplySucceeded, nil
+}
+
+// Sends a reply with the given code. The bound address is always reported
+// as 0.0.0.0:0, as clients don't need it for CONNECT.
+func writeSOCKS5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,158 @@
+package frontend
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"golang.org/x/net/proxy"
+
+	lproxy "github.com/giantswarm/linkmeup/pkg/proxy"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Starts a TCP server echoing everything it receives and returns its address.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// Starts a SOCKS5 server standing in for a tunnel. It connects every request
+// to target, regardless of the requested address, and returns its port.
+func startFakeTunnel(t *testing.T, target string) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	dial := func(ctx context.Context, host, addr string) (net.Conn, byte, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return nil, socks5ReplyGeneralFailure, err
+		}
+		return conn, socks5ReplySucceeded, nil
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleSOCKS5(testLogger(), conn, dial)
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestSOCKS5Server(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxies := []*lproxy.Proxy{
+		{Name: "one", Domain: "one.example", Port: startFakeTunnel(t, echoAddr)},
+		{Name: "two", Domain: "two.example", Port: 1}, // nothing listening
+	}
+
+	s, err := NewSOCKS5Server(testLogger(), proxies, 1080)
+	if err != nil {
+		t.Fatalf("NewSOCKS5Server() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serve(l)
+	t.Cleanup(func() { _ = s.Close() })
+
+	client, err := proxy.SOCKS5("tcp", l.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("routes matching host through tunnel", func(t *testing.T) {
+		conn, err := client.Dial("tcp", "happa.one.example:443")
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Errorf("got %q, want %q", buf, "ping")
+		}
+	})
+
+	t.Run("refuses unknown host", func(t *testing.T) {
+		_, err := client.Dial("tcp", "example.org:443")
+		if err == nil {
+			t.Fatal("Dial() should fail for a host without installation")
+		}
+	})
+
+	t.Run("fails if tunnel is down", func(t *testing.T) {
+		_, err := client.Dial("tcp", "happa.two.example:443")
+		if err == nil {
+			t.Fatal("Dial() should fail if the tunnel is not reachable")
+		}
+	})
+}
+
+func Test_route(t *testing.T) {
+	proxies := []*lproxy.Proxy{
+		{Name: "outer", Domain: "example.com"},
+		{Name: "inner", Domain: "inner.example.com"},
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "a.example.com", want: "outer"},
+		{host: "a.inner.example.com", want: "inner"},
+		{host: "inner.example.com", want: "inner"},
+		{host: "example.org", want: ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := route(proxies, tt.host); p != nil {
+			got = p.Name
+		}
+		if got != tt.want {
+			t.Errorf("route(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attribute the components log the installation name under.
+const installationKey = "name"
+
+// Entry is a log record kept by Buffer.
+type Entry struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	// Installation the entry is about, empty if it is about none
+	Installation string
+	// The other attributes as space separated key=value pairs
+	Attrs string
+}
+
+// Buffer keeps the most recent log entries in memory.
+type Buffer struct {
+	size  int
+	level slog.Leveler
+
+	mu      sync.Mutex
+	entries []Entry
+	changed chan struct{}
+}
+
+// NewBuffer creates a buffer keeping the last size entries of at least the
+// given level.
+func NewBuffer(size int, level slog.Leveler) *Buffer {
+	return &Buffer{
+		size:    size,
+		level:   level,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Entries returns the buffered entries, oldest first.
+func (b *Buffer) Entries() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.entries)
+}
+
+// Changed returns a channel receiving a value when entries were added.
+// Additions while the previous value was not received yet are coalesced.
+func (b *Buffer) Changed() <-chan struct{} {
+	return b.changed
+}
+
+// Handler returns a slog handler adding records to the buffer.
+func (b *Buffer) Handler() slog.Handler {
+	return &bufferHandler{buffer: b}
+}
+
+// Appends an entry, dropping the oldest ones beyond the size.
+func (b *Buffer) add(e Entry) {
+	b.mu.Lock()
+	b.entries = append(b.entries, e)
+	if len(b.entries) > b.size {
+		b.entries = slices.Delete(b.entries, 0, len(b.entries)-b.size)
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// bufferHandler is the slog.Handler of a Buffer.
+type bufferHandler struct {
+	buffer *Buffer
+	// Attributes added by WithAttrs, with keys qualified by their groups
+	attrs []slog.Attr
+	// Groups opened by WithGroup, as prefix for keys
+	prefix string
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.buffer.level.Level()
+}
+
+func (h *bufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := Entry{Time: r.Time, Level: r.Level, Message: r.Message}
+
+	var attrs []string
+	add := func(a slog.Attr) {
+		if a.Key == installationKey && e.Installation == "" {
+			e.Installation = a.Value.Resolve().String()
+			return
+		}
+		attrs = append(attrs, a.Key+"="+a.Value.Resolve().String())
+	}
+
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		a.Key = h.prefix + a.Key
+		add(a)
+		return true
+	})
+	e.Attrs = strings.Join(attrs, " ")
+
+	h.buffer.add(e)
+	return nil
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clone(h.attrs)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
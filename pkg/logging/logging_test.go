@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "linkmeup.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", p, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(p), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept, stat of third one: %v", err)
+	}
+}
+
+func TestRotatingFile_append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linkmeup.log")
+	err := os.WriteFile(path, []byte("before\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 100, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	_, _ = f.Write([]byte("after\n"))
+	_ = f.Close()
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "before\nafter\n" {
+		t.Errorf("file = %q, want the new line appended", got)
+	}
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Error("Write() after Close() should fail")
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(2, slog.LevelInfo)
+	logger := slog.New(b.Handler())
+
+	logger.Debug("hidden")
+	logger.Info("first")
+	logger.With(slog.String("component", "proxy")).Warn("Proxy state changed", slog.String("name", "test"), slog.String("to", "Degraded"))
+	logger.WithGroup("req").Error("failed", slog.Int("status", 500))
+
+	select {
+	case <-b.Changed():
+	default:
+		t.Error("Changed() did not receive a value")
+	}
+
+	entries := b.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	e := entries[0]
+	if e.Message != "Proxy state changed" || e.Level != slog.LevelWarn || e.Installation != "test" || e.Attrs != "component=proxy to=Degraded" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if entries[1].Attrs != "req.status=500" {
+		t.Errorf("Attrs = %q, want req.status=500", entries[1].Attrs)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var debug, warn bytes.Buffer
+	logger := slog.New(Multi(
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewTextHandler(&warn, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	))
+
+	logger.Debug("details")
+	logger.Warn("problem")
+
+	if !strings.Contains(debug.String(), "details") || !strings.Contains(debug.String(), "problem") {
+		t.Errorf("debug handler got %q, want both records", debug.String())
+	}
+	if strings.Contains(warn.String(), "details") || !strings.Contains(warn.String(), "problem") {
+		t.Errorf("warn handler got %q, want only the warning", warn.String())
+	}
+}
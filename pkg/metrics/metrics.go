@@ -0,0 +1,259 @@
+// Package metrics exports the state of the proxies and the Teleport session
+// as Prometheus metrics. The state of the proxies is read when scraped, the
+// other metrics are updated from the events the proxies publish.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+const namespace = "linkmeup"
+
+// Reasons for failed health checks, used as label values.
+const (
+	ReasonTimeout    = "timeout"
+	ReasonStatus     = "status"
+	ReasonBody       = "body"
+	ReasonConnection = "connection"
+)
+
+// Metrics holds the collectors for all exported metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	pingDuration *prometheus.HistogramVec
+	pingFailures *prometheus.CounterVec
+	nodeSwitches *prometheus.CounterVec
+	validUntil   *prometheus.GaugeVec
+
+	mu sync.Mutex
+	// Teleport sessions by profile name
+	sessions map[string]*tshstatus.Profile
+	// Proxies whose state is exported, set by Run
+	proxies []*proxy.Proxy
+}
+
+// New creates the metrics. start is when linkmeup was started, used for the
+// uptime.
+func New(start time.Time) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		pingDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ping_duration_seconds",
+			Help:      "Duration of health checks through the tunnel.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"installation"}),
+		pingFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ping_failures_total",
+			Help:      "Number of failed health checks by reason (timeout, status, body, connection).",
+		}, []string{"installation", "reason"}),
+		nodeSwitches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_switches_total",
+			Help:      "Number of times the tunnel moved to a different node.",
+		}, []string{"installation"}),
+		validUntil: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "teleport_session_valid_until_seconds",
+			Help:      "Unix time the Teleport session of the profile expires at.",
+		}, []string{"profile"}),
+		sessions: make(map[string]*tshstatus.Profile),
+	}
+
+	uptime := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "uptime_seconds",
+		Help:      "Seconds since linkmeup was started.",
+	}, func() float64 {
+		return time.Since(start).Seconds()
+	})
+
+	m.registry.MustRegister(
+		m.pingDuration, m.pingFailures, m.nodeSwitches,
+		m.validUntil, uptime, sessionRemaining{m}, proxyState{m},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SetSession sets the Teleport session of the named profile whose validity
+// is exported. A nil profile removes it.
+func (m *Metrics) SetSession(name string, profile *tshstatus.Profile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if profile == nil {
+		delete(m.sessions, name)
+		m.validUntil.DeleteLabelValues(name)
+		return
+	}
+	m.sessions[name] = profile
+	m.validUntil.WithLabelValues(name).Set(float64(profile.ValidUntil.Unix()))
+}
+
+// Returns the seconds until the session of the profile expires.
+func (m *Metrics) sessionRemaining(name string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session := m.sessions[name]
+	if session == nil {
+		return 0
+	}
+	return max(time.Until(session.ValidUntil).Seconds(), 0)
+}
+
+var sessionRemainingDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "teleport_session_remaining_seconds"),
+	"Seconds until the Teleport session of the profile expires, 0 if it has expired.",
+	[]string{"profile"}, nil,
+)
+
+// sessionRemaining collects the time left of each Teleport session when
+// scraped.
+type sessionRemaining struct {
+	m *Metrics
+}
+
+func (c sessionRemaining) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionRemainingDesc
+}
+
+func (c sessionRemaining) Collect(ch chan<- prometheus.Metric) {
+	c.m.mu.Lock()
+	names := make([]string, 0, len(c.m.sessions))
+	for name := range c.m.sessions {
+		names = append(names, name)
+	}
+	c.m.mu.Unlock()
+
+	for _, name := range names {
+		ch <- prometheus.MustNewConstMetric(sessionRemainingDesc, prometheus.GaugeValue, c.m.sessionRemaining(name), name)
+	}
+}
+
+var (
+	proxyHealthyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "proxy_healthy"),
+		"Whether the proxy of the installation is healthy (1) or not (0).",
+		[]string{"installation"}, nil,
+	)
+	tunnelRestartsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "tunnel_restarts_total"),
+		"Number of times the tunnel of the installation was started again after it exited. Restarts on request or after failed health checks are not counted.",
+		[]string{"installation"}, nil,
+	)
+	tunnelUptimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "tunnel_uptime_seconds"),
+		"Seconds since the tunnel process of the installation was started, 0 if there is none.",
+		[]string{"installation"}, nil,
+	)
+)
+
+// proxyState collects the health, restarts and tunnel uptime of each proxy
+// from its current status when scraped.
+type proxyState struct {
+	m *Metrics
+}
+
+func (c proxyState) Describe(ch chan<- *prometheus.Desc) {
+	ch <- proxyHealthyDesc
+	ch <- tunnelRestartsDesc
+	ch <- tunnelUptimeDesc
+}
+
+func (c proxyState) Collect(ch chan<- prometheus.Metric) {
+	c.m.mu.Lock()
+	proxies := c.m.proxies
+	c.m.mu.Unlock()
+
+	for _, p := range proxies {
+		status := p.Status()
+
+		healthy := 0.0
+		if status.Healthy {
+			healthy = 1
+		}
+		uptime := 0.0
+		if !status.TunnelSince.IsZero() {
+			uptime = time.Since(status.TunnelSince).Seconds()
+		}
+
+		ch <- prometheus.MustNewConstMetric(proxyHealthyDesc, prometheus.GaugeValue, healthy, status.Name)
+		ch <- prometheus.MustNewConstMetric(tunnelRestartsDesc, prometheus.CounterValue, float64(status.Restarts), status.Name)
+		ch <- prometheus.MustNewConstMetric(tunnelUptimeDesc, prometheus.GaugeValue, uptime, status.Name)
+	}
+}
+
+// Run exports the state of the proxies and updates the metrics from their
+// events until ctx is cancelled.
+func (m *Metrics) Run(ctx context.Context, proxies []*proxy.Proxy) {
+	m.mu.Lock()
+	m.proxies = proxies
+	m.mu.Unlock()
+
+	for _, p := range proxies {
+		// Export the counters before anything happened
+		m.nodeSwitches.WithLabelValues(p.Name)
+
+		// Observed rather than subscribed to, as a subscription drops
+		// events when it falls behind and the counters would miss them
+		stop := p.Observe(m.update)
+		defer stop()
+	}
+
+	<-ctx.Done()
+}
+
+// Updates the metrics from one event. Called with the proxy locked.
+func (m *Metrics) update(e proxy.Event) {
+	name := e.Info().Proxy
+
+	switch e := e.(type) {
+	case proxy.NodeSwitchedEvent:
+		if e.Previous != "" {
+			m.nodeSwitches.WithLabelValues(name).Inc()
+		}
+	case proxy.PingResultEvent:
+		m.pingDuration.WithLabelValues(name).Observe(e.Duration.Seconds())
+		if !e.Success {
+			m.pingFailures.WithLabelValues(name, failureReason(e.Err)).Inc()
+		}
+	}
+}
+
+// Classifies why a health check failed.
+func failureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, proxy.ErrUnexpectedStatus):
+		return ReasonStatus
+	case errors.Is(err, proxy.ErrBodyMismatch):
+		return ReasonBody
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	default:
+		return ReasonConnection
+	}
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "status", err: fmt.Errorf("%w 503", proxy.ErrUnexpectedStatus), want: ReasonStatus},
+		{name: "body", err: fmt.Errorf("%w %q", proxy.ErrBodyMismatch, "ok"), want: ReasonBody},
+		{name: "deadline", err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), want: ReasonTimeout},
+		{name: "connection", err: errors.New("connection refused"), want: ReasonConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics_update(t *testing.T) {
+	m := New(time.Now())
+	info := proxy.EventInfo{Proxy: "test", Time: time.Now()}
+
+	// Selecting the first node is not a switch
+	m.update(proxy.NodeSwitchedEvent{EventInfo: info, Node: "node-a"})
+	m.update(proxy.NodeSwitchedEvent{EventInfo: info, Previous: "node-a", Node: "node-b"})
+	if got := testutil.ToFloat64(m.nodeSwitches.WithLabelValues("test")); got != 1 {
+		t.Errorf("node_switches_total = %v, want 1", got)
+	}
+
+	m.update(proxy.PingResultEvent{EventInfo: info, Success: true, Duration: 100 * time.Millisecond})
+	m.update(proxy.PingResultEvent{EventInfo: info, Duration: time.Second, Err: fmt.Errorf("%w 503", proxy.ErrUnexpectedStatus)})
+	if got := testutil.ToFloat64(m.pingFailures.WithLabelValues("test", ReasonStatus)); got != 1 {
+		t.Errorf("ping_failures_total{reason=status} = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(m.pingDuration); got != 1 {
+		t.Errorf("got %d ping duration histograms, want 1", got)
+	}
+}
+
+// fakeBackend opens tunnels that run until closed or told to exit.
+type fakeBackend struct {
+	mu      sync.Mutex
+	tunnels []*fakeTunnel
+}
+
+func (b *fakeBackend) Nodes() ([]string, error) {
+	return []string{"node-a", "node-b"}, nil
+}
+
+func (b *fakeBackend) Open(node string, port int) (proxy.Tunnel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tunnel := &fakeTunnel{done: make(chan struct{})}
+	b.tunnels = append(b.tunnels, tunnel)
+	return tunnel, nil
+}
+
+// Waits until the backend has opened n tunnels and returns the last one.
+func (b *fakeBackend) waitForTunnel(t *testing.T, n int) *fakeTunnel {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		tunnels := b.tunnels
+		b.mu.Unlock()
+		if len(tunnels) >= n {
+			return tunnels[n-1]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d tunnels", n)
+	return nil
+}
+
+type fakeTunnel struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (t *fakeTunnel) Wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *fakeTunnel) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+// Returns the value of the metric of the proxy "test" with the given name.
+func proxyMetric(t *testing.T, m *Metrics, name string) float64 {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != namespace+"_"+name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "installation" && label.GetValue() == "test" {
+					if metric.GetCounter() != nil {
+						return metric.GetCounter().GetValue()
+					}
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("metric %s of proxy test not found", name)
+	return 0
+}
+
+func TestMetrics_Run(t *testing.T) {
+	backend := &fakeBackend{}
+	p, err := proxy.New(slog.New(slog.DiscardHandler), "test", "example.com", proxy.HealthCheck{URL: "https://example.com"}, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer p.Stop()
+
+	m := New(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, []*proxy.Proxy{p})
+		close(done)
+	}()
+
+	// Wait until Run observes the proxy
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.CollectAndCount(proxyState{m}) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the proxy metrics")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := proxyMetric(t, m, "proxy_healthy"); got != 0 {
+		t.Errorf("proxy_healthy = %v, want 0", got)
+	}
+	if got := proxyMetric(t, m, "tunnel_restarts_total"); got != 0 {
+		t.Errorf("tunnel_restarts_total = %v, want 0", got)
+	}
+
+	first := backend.waitForTunnel(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	before := proxyMetric(t, m, "tunnel_uptime_seconds")
+	if before < 0.05 {
+		t.Errorf("tunnel_uptime_seconds = %v, want at least 0.05", before)
+	}
+
+	// The tunnel exits and is started again on the other node
+	first.Close()
+	// The proxy is locked while opening the tunnel, so the restart is
+	// counted by the time the status can be read
+	backend.waitForTunnel(t, 2)
+	if got := proxyMetric(t, m, "tunnel_restarts_total"); got != 1 {
+		t.Errorf("tunnel_restarts_total = %v, want 1", got)
+	}
+	if got := proxyMetric(t, m, "tunnel_uptime_seconds"); got >= before {
+		t.Errorf("tunnel_uptime_seconds = %v after restart, want less than %v", got, before)
+	}
+	if got := proxyMetric(t, m, "node_switches_total"); got != 1 {
+		t.Errorf("node_switches_total = %v, want 1", got)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMetrics_SetSession(t *testing.T) {
+	m := New(time.Now())
+	if got := m.sessionRemaining("default"); got != 0 {
+		t.Errorf("sessionRemaining() = %v without session, want 0", got)
+	}
+
+	validUntil := time.Now().Add(time.Hour)
+	m.SetSession("default", &tshstatus.Profile{ValidUntil: validUntil})
+	m.SetSession("other", &tshstatus.Profile{ValidUntil: time.Now().Add(-time.Hour)})
+	if got := testutil.ToFloat64(m.validUntil.WithLabelValues("default")); got != float64(validUntil.Unix()) {
+		t.Errorf("teleport_session_valid_until_seconds{profile=default} = %v, want %v", got, validUntil.Unix())
+	}
+	if got := m.sessionRemaining("default"); got <= 0 || got > time.Hour.Seconds() {
+		t.Errorf("sessionRemaining(default) = %v, want within an hour", got)
+	}
+	if got := m.sessionRemaining("other"); got != 0 {
+		t.Errorf("sessionRemaining(other) = %v for expired session, want 0", got)
+	}
+	if got := testutil.CollectAndCount(sessionRemaining{m}); got != 2 {
+		t.Errorf("got %d teleport_session_remaining_seconds series, want one per profile", got)
+	}
+
+	// A new login to one profile leaves the other alone
+	m.SetSession("other", &tshstatus.Profile{ValidUntil: validUntil})
+	if got := m.sessionRemaining("other"); got <= 0 {
+		t.Errorf("sessionRemaining(other) = %v after login, want positive", got)
+	}
+	if got := testutil.ToFloat64(m.validUntil.WithLabelValues("default")); got != float64(validUntil.Unix()) {
+		t.Errorf("teleport_session_valid_until_seconds{profile=default} = %v after login to other, want %v", got, validUntil.Unix())
+	}
+}
@@ -0,0 +1,151 @@
+package pacserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+// Status is the response of the /api/status endpoint.
+type Status struct {
+	// Whether all proxies are healthy
+	Healthy bool `json:"healthy"`
+	// Status of each installation's proxy
+	Proxies []ProxyStatus `json:"proxies"`
+	// Session of the active Teleport profile, if known
+	Teleport *TeleportStatus `json:"teleport,omitempty"`
+	// Sessions of the Teleport profiles the installations use
+	TeleportProfiles []TeleportStatus `json:"teleport_profiles,omitempty"`
+}
+
+// ProxyStatus is the status of one proxy in the /api/status response.
+type ProxyStatus struct {
+	Name        string    `json:"name"`
+	Domain      string    `json:"domain"`
+	Port        int       `json:"port"`
+	State       string    `json:"state"`
+	StateReason string    `json:"state_reason,omitempty"`
+	StateSince  time.Time `json:"state_since"`
+	Healthy     bool      `json:"healthy"`
+	ActiveNode  string    `json:"active_node,omitempty"`
+	Nodes       []string  `json:"nodes"`
+	Restarts    int       `json:"restarts"`
+	LastExitErr string    `json:"last_exit_error,omitempty"`
+}
+
+// TeleportStatus is a Teleport session in the /api/status response.
+type TeleportStatus struct {
+	// Name of the profile in the config, only set in TeleportProfiles
+	Profile    string    `json:"profile,omitempty"`
+	Proxy      string    `json:"proxy,omitempty"`
+	Cluster    string    `json:"cluster"`
+	Username   string    `json:"username"`
+	ValidUntil time.Time `json:"valid_until"`
+}
+
+// SetTeleportStatus sets the Teleport session reported by /api/status.
+func (p *PacServer) SetTeleportStatus(status *tshstatus.Status) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.teleport = status
+}
+
+// SetTeleportSession sets the session of the named Teleport profile
+// reported by /api/status. Profiles are reported in the order they were
+// first set.
+func (p *PacServer) SetTeleportSession(name string, profile *tshstatus.Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !slices.Contains(p.profiles, name) {
+		p.profiles = append(p.profiles, name)
+	}
+	p.sessions[name] = profile
+}
+
+// Status returns the current status of linkmeup.
+func (p *PacServer) Status() Status {
+	status := Status{
+		Healthy: true,
+		Proxies: make([]ProxyStatus, 0, len(p.proxies)),
+	}
+
+	for _, px := range p.proxies {
+		s := px.Status()
+		status.Healthy = status.Healthy && s.Healthy
+		status.Proxies = append(status.Proxies, newProxyStatus(s))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.teleport != nil && p.teleport.Active != nil {
+		s := newTeleportStatus("", p.teleport.Active)
+		status.Teleport = &s
+	}
+	for _, name := range p.profiles {
+		if profile := p.sessions[name]; profile != nil {
+			status.TeleportProfiles = append(status.TeleportProfiles, newTeleportStatus(name, profile))
+		}
+	}
+
+	return status
+}
+
+// Converts a Teleport profile to its JSON representation.
+func newTeleportStatus(name string, profile *tshstatus.Profile) TeleportStatus {
+	return TeleportStatus{
+		Profile:    name,
+		Proxy:      profile.ProxyHost(),
+		Cluster:    profile.Cluster,
+		Username:   profile.Username,
+		ValidUntil: profile.ValidUntil,
+	}
+}
+
+// Converts the status of a proxy to its JSON representation.
+func newProxyStatus(s proxy.ProxyStatus) ProxyStatus {
+	nodes := s.Nodes
+	if nodes == nil {
+		nodes = []string{}
+	}
+
+	ps := ProxyStatus{
+		Name:        s.Name,
+		Domain:      s.Domain,
+		Port:        s.Port,
+		State:       s.State.String(),
+		StateReason: s.StateReason,
+		StateSince:  s.StateSince,
+		Healthy:     s.Healthy,
+		ActiveNode:  s.ActiveNode,
+		Nodes:       nodes,
+		Restarts:    s.Restarts,
+	}
+	if s.LastExitErr != nil {
+		ps.LastExitErr = s.LastExitErr.Error()
+	}
+	return ps
+}
+
+// Serves the status of linkmeup as JSON.
+func (p *PacServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := json.NewEncoder(w).Encode(p.Status())
+	if err != nil {
+		p.logger.Debug("Failed to write status response", slog.String("error", err.Error()))
+	}
+}
+
+// Reports that linkmeup itself is up, regardless of the proxies.
+func (p *PacServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,67 @@
+package pacserver
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+func TestPacServer_handleStatus(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	proxies := []*proxy.Proxy{{Name: "test-installation", Domain: "example.com", Port: 1081}}
+	s, err := New(logger, proxies, 9999, ProxyTypeSOCKS5, 1080, UnhealthyProxy)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	validUntil := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.SetTeleportStatus(&tshstatus.Status{Active: &tshstatus.Profile{Cluster: "teleport.example.com", Username: "jane", ValidUntil: validUntil}})
+	s.SetTeleportSession("default", &tshstatus.Profile{ProfileURL: "https://teleport.example.com:443", Cluster: "teleport.example.com", Username: "jane", ValidUntil: validUntil})
+	s.SetTeleportSession("other", &tshstatus.Profile{ProfileURL: "https://other.example.com:443", Cluster: "other.example.com", Username: "jane", ValidUntil: validUntil.Add(time.Hour)})
+
+	rec := httptest.NewRecorder()
+	s.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var status Status
+	err = json.NewDecoder(rec.Body).Decode(&status)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if status.Healthy {
+		t.Error("Healthy = true, want false for a proxy that was never checked")
+	}
+	if len(status.Proxies) != 1 {
+		t.Fatalf("got %d proxies, want 1", len(status.Proxies))
+	}
+	p := status.Proxies[0]
+	if p.Name != "test-installation" || p.Domain != "example.com" || p.Port != 1081 || p.State != "Starting" {
+		t.Errorf("unexpected proxy status %+v", p)
+	}
+	if status.Teleport == nil || status.Teleport.Username != "jane" || !status.Teleport.ValidUntil.Equal(validUntil) {
+		t.Errorf("unexpected Teleport status %+v", status.Teleport)
+	}
+	if got := status.TeleportProfiles; len(got) != 2 || got[0].Profile != "default" || got[1].Profile != "other" || got[1].Proxy != "other.example.com" || !got[1].ValidUntil.Equal(validUntil.Add(time.Hour)) {
+		t.Errorf("unexpected Teleport profiles %+v", got)
+	}
+}
+
+func TestPacServer_handleHealthz(t *testing.T) {
+	s := &PacServer{}
+	rec := httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -1,24 +1,86 @@
 // Package pacserver implements a simple PAC (Proxy Auto-Configuration) server.
+// The same local web server exposes the status of linkmeup as JSON at
+// /api/status and its own liveness at /healthz.
 package pacserver
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
 )
 
+const (
+	// ProxyTypeSOCKS5 makes the PAC file point to the SOCKS5 proxy.
+	ProxyTypeSOCKS5 = "socks5"
+	// ProxyTypeHTTP makes the PAC file point to the HTTP proxy.
+	ProxyTypeHTTP = "http"
+
+	// UnhealthyProxy keeps sending traffic for unhealthy installations to
+	// the local proxy.
+	UnhealthyProxy = "proxy"
+	// UnhealthyFallback lets clients connect directly if the local proxy
+	// fails for an unhealthy installation.
+	UnhealthyFallback = "fallback"
+	// UnhealthyOmit leaves unhealthy installations out of the PAC file, so
+	// clients connect directly.
+	UnhealthyOmit = "omit"
+)
+
+// Time granted to running requests when the server shuts down.
+const shutdownTimeout = 5 * time.Second
+
+// DefaultAddress is the address the server listens on by default, loopback
+// only.
+const DefaultAddress = "127.0.0.1"
+
+// DefaultPaths are the paths the PAC file is served at by default. /wpad.dat
+// is where Web Proxy Auto-Discovery clients look for it.
+var DefaultPaths = []string{"/proxy.pac", "/wpad.dat"}
+
 type PacServer struct {
-	logger *slog.Logger
-	server *http.Server
+	logger    *slog.Logger
+	server    *http.Server
+	mux       *http.ServeMux
+	proxies   []*proxy.Proxy
+	directive string
+	unhealthy string
+
+	// Guards teleport, profiles and sessions
+	mu       sync.Mutex
+	teleport *tshstatus.Status
+	// Names of the Teleport profiles in the order they were set
+	profiles []string
+	sessions map[string]*tshstatus.Profile
 
 	Port int
-	Body string
+	// Address to listen on, DefaultAddress unless changed before Serve
+	Address string
+	// Paths to serve the PAC file at, DefaultPaths unless changed before
+	// Serve
+	Paths []string
+	// Optional PAC file to fall back to for hosts not handled by the
+	// proxies, instead of connecting directly
+	Upstream *Upstream
 }
 
-func New(logger *slog.Logger, proxies []*proxy.Proxy, port int) (*PacServer, error) {
+// New creates a PAC server on the given port. The PAC file directs traffic
+// for all installations to the local proxy of the given type (ProxyTypeSOCKS5
+// or ProxyTypeHTTP) on proxyPort. How installations that are unhealthy or
+// have no nodes are handled is set by unhealthy, one of UnhealthyProxy,
+// UnhealthyFallback and UnhealthyOmit.
+func New(logger *slog.Logger, proxies []*proxy.Proxy, port int, proxyType string, proxyPort int, unhealthy string) (*PacServer, error) {
 	if proxies == nil {
 		return nil, fmt.Errorf("proxies cannot be nil")
 	}
@@ -29,46 +91,211 @@ func New(logger *slog.Logger, proxies []*proxy.Proxy, port int) (*PacServer, err
 		port = 9999 // Default port
 	}
 
+	var directive string
+	switch proxyType {
+	case ProxyTypeSOCKS5:
+		directive = fmt.Sprintf("SOCKS5 localhost:%d", proxyPort)
+	case ProxyTypeHTTP:
+		directive = fmt.Sprintf("PROXY localhost:%d", proxyPort)
+	default:
+		return nil, fmt.Errorf("invalid proxy type: %q", proxyType)
+	}
+
+	switch unhealthy {
+	case UnhealthyProxy, UnhealthyFallback, UnhealthyOmit:
+	default:
+		return nil, fmt.Errorf("invalid handling of unhealthy installations: %q", unhealthy)
+	}
+
 	return &PacServer{
-		logger: logger,
-		Body:   renderPacFile(proxies),
-		Port:   port,
+		logger:    logger,
+		mux:       http.NewServeMux(),
+		proxies:   proxies,
+		directive: directive,
+		unhealthy: unhealthy,
+		sessions:  make(map[string]*tshstatus.Profile),
+		Port:      port,
+		Address:   DefaultAddress,
+		Paths:     slices.Clone(DefaultPaths),
 	}, nil
 }
 
-func (p *PacServer) Serve() {
-	// Create web server to serve PAC
-	path := "/proxy.pac"
-	url := fmt.Sprintf("http://localhost:%d%s", p.Port, path)
-	p.logger.Info("Serving proxy auto-configuration (PAC) file", slog.String("url", url))
+// Body renders the PAC file from the current state of the proxies.
+func (p *PacServer) Body() string {
+	statuses := make([]proxy.ProxyStatus, 0, len(p.proxies))
+	for _, px := range p.proxies {
+		statuses = append(statuses, px.Status())
+	}
+	upstream := ""
+	if p.Upstream != nil {
+		upstream = p.Upstream.Script()
+	}
+	return renderPacFile(statuses, p.directive, p.unhealthy, upstream)
+}
 
-	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		p.logger.Debug("Serving request to PAC file", slog.String("url", r.URL.String()))
-		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
-		_, _ = fmt.Fprint(w, p.Body)
-	})
+// Serves the PAC file. It is rendered for every request, and the ETag lets
+// clients revalidate cheaply whether it changed.
+func (p *PacServer) handlePAC(w http.ResponseWriter, r *http.Request) {
+	p.logger.Debug("Serving request to PAC file", slog.String("url", r.URL.String()))
+
+	body := p.Body()
+	sum := sha256.Sum256([]byte(body))
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:8]))
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+}
+
+// Serve starts the web server serving the PAC file in the background. When
+// ctx is cancelled, the server is shut down gracefully.
+func (p *PacServer) Serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(p.Address, strconv.Itoa(p.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", p.Port, err)
+	}
+	p.logger.Info("Serving proxy auto-configuration (PAC) file", slog.String("url", p.URL()))
+	p.serve(ctx, listener)
+
+	return nil
+}
+
+// Handles requests on listener in the background until ctx is cancelled.
+func (p *PacServer) serve(ctx context.Context, listener net.Listener) {
+	for _, path := range p.Paths {
+		p.mux.HandleFunc(path, p.handlePAC)
+	}
+	p.mux.HandleFunc("GET /api/status", p.handleStatus)
+	p.mux.HandleFunc("GET /healthz", p.handleHealthz)
+
+	p.server = &http.Server{
+		Handler:      p.mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  5 * time.Second,
+	}
 
 	go func() {
-		p.server = &http.Server{
-			Addr:         fmt.Sprintf(":%d", p.Port),
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 5 * time.Second,
-			IdleTimeout:  5 * time.Second,
+		err := p.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.logger.Error("Auto-configuration web server error", slog.String("error", err.Error()))
 		}
-		err := p.server.ListenAndServe()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := p.server.Shutdown(shutdownCtx)
 		if err != nil {
-			p.logger.Error("Auto-configuration web server error", slog.String("error", err.Error()))
+			p.logger.Error("Failed to shut down auto-configuration web server", slog.String("error", err.Error()))
 		}
 	}()
 }
 
-func renderPacFile(proxies []*proxy.Proxy) string {
-	// Generate PAC from privateInstallations and port numbers.
-	body := "function FindProxyForURL(url, host) {"
-	for _, p := range proxies {
-		body += fmt.Sprintf("\n  if (dnsDomainIs(host, '%s')) { return 'SOCKS5 localhost:%d'; }", p.Domain, p.Port)
+// Handle registers an additional handler on the web server, like the
+// handler for metrics. Must be called before Serve.
+func (p *PacServer) Handle(pattern string, handler http.Handler) {
+	p.mux.Handle(pattern, handler)
+}
+
+// URL returns the address clients can load the PAC file from.
+func (p *PacServer) URL() string {
+	host := p.Address
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	path := "/"
+	if len(p.Paths) > 0 {
+		path = p.Paths[0]
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(p.Port)), path)
+}
+
+// Renders the PAC file. All installations are served by the same local
+// proxy, which routes by host name, so they share the directive (like
+// "SOCKS5 localhost:1080"). Installations whose proxy is not working are
+// handled according to unhealthy. Like the local proxy, the installation
+// with the longest domain wins if several match. All other hosts go
+// directly, or to the FindProxyForURL of the upstream PAC file if given.
+func renderPacFile(statuses []proxy.ProxyStatus, directive string, unhealthy string, upstream string) string {
+	statuses = slices.Clone(statuses)
+	slices.SortStableFunc(statuses, func(a, b proxy.ProxyStatus) int {
+		return len(b.Domain) - len(a.Domain)
+	})
+
+	body := ""
+	fallback := "'DIRECT'"
+	if upstream != "" {
+		body = wrapUpstream(upstream)
+		fallback = "upstreamFindProxyForURL(url, host)"
 	}
-	body += "\n  return 'DIRECT';\n}\n"
+
+	body += "function FindProxyForURL(url, host) {"
+	for _, s := range statuses {
+		d := directive
+		if !usable(s.State) {
+			switch unhealthy {
+			case UnhealthyOmit:
+				continue
+			case UnhealthyFallback:
+				d += "; DIRECT"
+			}
+		}
+		body += fmt.Sprintf("\n  if (%s) { return '%s'; }", condition(s.Domain, s.Hosts), d)
+	}
+	body += fmt.Sprintf("\n  return %s;\n}\n", fallback)
 
 	return body
 }
+
+// Conditions of the PAC file that are true for IPv4 and IPv6 literals.
+// isInNet and isInNetEx resolve host names, which blocks the browser, so
+// they are only used for literals like proxy.Proxy.Matches does.
+const (
+	isIPv4Literal = `/^\d+\.\d+\.\d+\.\d+$/.test(host)`
+	isIPv6Literal = `host.indexOf(':') >= 0`
+)
+
+// Returns the JavaScript condition matching the hosts of an installation,
+// mirroring proxy.Proxy.Matches.
+func condition(domain string, hosts proxy.Hosts) string {
+	matches := []string{fmt.Sprintf("dnsDomainIs(host, '%s')", domain)}
+	for _, d := range hosts.Domains {
+		matches = append(matches, fmt.Sprintf("dnsDomainIs(host, '%s')", d))
+	}
+	for _, p := range hosts.Patterns {
+		matches = append(matches, fmt.Sprintf("shExpMatch(host, '%s')", p))
+	}
+	for _, n := range hosts.Networks {
+		if n.IP.To4() != nil {
+			matches = append(matches, fmt.Sprintf("(%s && isInNet(host, '%s', '%s'))", isIPv4Literal, n.IP, net.IP(n.Mask)))
+		} else {
+			matches = append(matches, fmt.Sprintf("(%s && isInNetEx(host, '%s'))", isIPv6Literal, n))
+		}
+	}
+
+	cond := strings.Join(matches, " || ")
+	if len(hosts.Exclude) == 0 {
+		return cond
+	}
+
+	if len(matches) > 1 {
+		cond = "(" + cond + ")"
+	}
+	excludes := make([]string, 0, len(hosts.Exclude))
+	for _, e := range hosts.Exclude {
+		excludes = append(excludes, fmt.Sprintf("!shExpMatch(host, '%s')", e))
+	}
+	return strings.Join(excludes, " && ") + " && " + cond
+}
+
+// Returns whether a proxy in the given state is, or is about to be, able to
+// serve traffic.
+func usable(state proxy.State) bool {
+	switch state {
+	case proxy.StateStarting, proxy.StateConnecting, proxy.StateHealthy:
+		return true
+	default:
+		return false
+	}
+}
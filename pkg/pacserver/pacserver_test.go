@@ -2,6 +2,12 @@
 package pacserver
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/giantswarm/linkmeup/pkg/proxy"
@@ -9,28 +15,221 @@ import (
 
 func Test_renderPacFile(t *testing.T) {
 	tests := []struct {
-		name    string
-		proxies []*proxy.Proxy
-		want    string
+		name      string
+		statuses  []proxy.ProxyStatus
+		directive string
+		unhealthy string
+		upstream  string
+		want      string
 	}{
 		{
 			name: "single proxy",
-			proxies: []*proxy.Proxy{
-				{Name: "test-installation", Port: 1080, Domain: "example.com"},
+			statuses: []proxy.ProxyStatus{
+				{Name: "test-installation", Port: 1081, Domain: "example.com", State: proxy.StateHealthy},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "multiple proxies share the front-end port",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Port: 1081, Domain: "one.example.com", State: proxy.StateHealthy},
+				{Name: "two", Port: 1082, Domain: "two.example.com", State: proxy.StateConnecting},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'one.example.com')) { return 'SOCKS5 localhost:1080'; }\n  if (dnsDomainIs(host, 'two.example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "http proxy",
+			statuses: []proxy.ProxyStatus{
+				{Name: "test-installation", Port: 1081, Domain: "example.com", State: proxy.StateHealthy},
+			},
+			directive: "PROXY localhost:8080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'example.com')) { return 'PROXY localhost:8080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name:      "empty proxies list",
+			statuses:  []proxy.ProxyStatus{},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "unhealthy kept",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Domain: "one.example.com", State: proxy.StateDegraded},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'one.example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "unhealthy with fallback",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Domain: "one.example.com", State: proxy.StateHealthy},
+				{Name: "two", Domain: "two.example.com", State: proxy.StateNoNodes},
 			},
-			want: "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyFallback,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'one.example.com')) { return 'SOCKS5 localhost:1080'; }\n  if (dnsDomainIs(host, 'two.example.com')) { return 'SOCKS5 localhost:1080; DIRECT'; }\n  return 'DIRECT';\n}\n",
 		},
 		{
-			name:    "empty proxies list",
-			proxies: []*proxy.Proxy{},
-			want:    "function FindProxyForURL(url, host) {\n  return 'DIRECT';\n}\n",
+			name: "unhealthy omitted",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Domain: "one.example.com", State: proxy.StateDegraded},
+				{Name: "two", Domain: "two.example.com", State: proxy.StateStarting},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyOmit,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'two.example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "longest domain first",
+			statuses: []proxy.ProxyStatus{
+				{Name: "base", Domain: "example.com", State: proxy.StateHealthy},
+				{Name: "sub", Domain: "sub.example.com", State: proxy.StateHealthy},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'sub.example.com')) { return 'SOCKS5 localhost:1080'; }\n  if (dnsDomainIs(host, 'example.com')) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "extra hosts and exclusions",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Domain: "example.com", State: proxy.StateHealthy, Hosts: proxy.Hosts{
+					Domains:  []string{"example.org"},
+					Patterns: []string{"grafana-*.example.net"},
+					Exclude:  []string{"public.example.com"},
+					Networks: []*net.IPNet{mustParseCIDR(t, "10.0.0.0/16"), mustParseCIDR(t, "fd00::/8")},
+				}},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			want:      "function FindProxyForURL(url, host) {\n  if (!shExpMatch(host, 'public.example.com') && (dnsDomainIs(host, 'example.com') || dnsDomainIs(host, 'example.org') || shExpMatch(host, 'grafana-*.example.net') || (/^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host) && isInNet(host, '10.0.0.0', '255.255.0.0')) || (host.indexOf(':') >= 0 && isInNetEx(host, 'fd00::/8')))) { return 'SOCKS5 localhost:1080'; }\n  return 'DIRECT';\n}\n",
+		},
+		{
+			name: "upstream fallback",
+			statuses: []proxy.ProxyStatus{
+				{Name: "one", Domain: "example.com", State: proxy.StateHealthy},
+			},
+			directive: "SOCKS5 localhost:1080",
+			unhealthy: UnhealthyProxy,
+			upstream:  "function FindProxyForURL(url, host) { return 'PROXY corp:3128'; }",
+			want:      "var upstreamFindProxyForURL = (function () {\nfunction FindProxyForURL(url, host) { return 'PROXY corp:3128'; }\nreturn FindProxyForURL;\n})();\n\nfunction FindProxyForURL(url, host) {\n  if (dnsDomainIs(host, 'example.com')) { return 'SOCKS5 localhost:1080'; }\n  return upstreamFindProxyForURL(url, host);\n}\n",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := renderPacFile(tt.proxies); got != tt.want {
+			if got := renderPacFile(tt.statuses, tt.directive, tt.unhealthy, tt.upstream); got != tt.want {
 				t.Errorf("renderPacFile() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q) error = %v", s, err)
+	}
+	return n
+}
+
+func TestPacServer_handlePAC(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	proxies := []*proxy.Proxy{{Name: "test-installation", Domain: "example.com"}}
+	s, err := New(logger, proxies, 9999, ProxyTypeSOCKS5, 1080, UnhealthyProxy)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handlePAC(rec, httptest.NewRequest(http.MethodGet, "/proxy.pac", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Body.String(); got != s.Body() {
+		t.Errorf("body = %q, want %q", got, s.Body())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want %q", got, "no-cache")
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag not set")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/proxy.pac", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.handlePAC(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("status with matching If-None-Match = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestPacServer_serve(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := New(logger, []*proxy.Proxy{}, 9999, ProxyTypeSOCKS5, 1080, UnhealthyProxy)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.serve(ctx, l)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/proxy.pac", want: http.StatusOK},
+		{path: "/wpad.dat", want: http.StatusOK},
+		{path: "/other", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get("http://" + l.Addr().String() + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", tt.path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestPacServer_URL(t *testing.T) {
+	tests := []struct {
+		address string
+		paths   []string
+		want    string
+	}{
+		{address: "127.0.0.1", paths: DefaultPaths, want: "http://127.0.0.1:9999/proxy.pac"},
+		{address: "0.0.0.0", paths: []string{"/wpad.dat"}, want: "http://localhost:9999/wpad.dat"},
+		{address: "", paths: DefaultPaths, want: "http://localhost:9999/proxy.pac"},
+		{address: "::1", paths: DefaultPaths, want: "http://[::1]:9999/proxy.pac"},
+	}
+	for _, tt := range tests {
+		s := &PacServer{Port: 9999, Address: tt.address, Paths: tt.paths}
+		if got := s.URL(); got != tt.want {
+			t.Errorf("URL() with address %q = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestNew_unhealthy(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := New(logger, []*proxy.Proxy{}, 9999, ProxyTypeSOCKS5, 1080, "ignore")
+	if err == nil {
+		t.Error("New() with invalid unhealthy handling succeeded, want error")
+	}
+}
@@ -0,0 +1,151 @@
+package pacserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum size of an upstream PAC file.
+	maxUpstreamSize = 1 << 20
+	// Timeout for fetching an upstream PAC file from a URL.
+	upstreamTimeout = 30 * time.Second
+)
+
+// Upstream is an existing PAC file, like a corporate one, that linkmeup's
+// PAC file falls back to for all hosts not handled by the proxies.
+type Upstream struct {
+	logger  *slog.Logger
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu     sync.Mutex
+	script string
+}
+
+// NewUpstream creates an upstream PAC file loaded from source, which is
+// either an http(s) URL or a local file path, and reloaded every refresh.
+func NewUpstream(logger *slog.Logger, source string, refresh time.Duration) (*Upstream, error) {
+	if source == "" {
+		return nil, fmt.Errorf("source cannot be empty")
+	}
+	if refresh <= 0 {
+		return nil, fmt.Errorf("invalid refresh interval: %v", refresh)
+	}
+	if isURL(source) {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL: %w", err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q: no host", source)
+		}
+	}
+
+	return &Upstream{
+		logger:  logger,
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: upstreamTimeout},
+	}, nil
+}
+
+// Script returns the last successfully loaded PAC file, or an empty string
+// if none was loaded yet.
+func (u *Upstream) Script() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.script
+}
+
+// Load reads the PAC file from its source. On failure, the previously loaded
+// one is kept.
+func (u *Upstream) Load(ctx context.Context) error {
+	var (
+		body []byte
+		err  error
+	)
+	if isURL(u.source) {
+		body, err = u.fetch(ctx)
+	} else {
+		body, err = os.ReadFile(u.source)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load upstream PAC file from %s: %w", u.source, err)
+	}
+	if !strings.Contains(string(body), "FindProxyForURL") {
+		return fmt.Errorf("upstream PAC file from %s does not define FindProxyForURL", u.source)
+	}
+
+	u.mu.Lock()
+	u.script = string(body)
+	u.mu.Unlock()
+
+	return nil
+}
+
+// Run reloads the PAC file periodically until ctx is cancelled.
+func (u *Upstream) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := u.Load(ctx)
+			if err != nil && u.Script() == "" {
+				u.logger.Warn("Failed to load upstream PAC file, connecting other hosts directly", slog.String("error", err.Error()))
+				continue
+			}
+			if err != nil {
+				u.logger.Warn("Failed to refresh upstream PAC file, keeping the previous one", slog.String("error", err.Error()))
+				continue
+			}
+			u.logger.Debug("Refreshed upstream PAC file", slog.String("source", u.source))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Returns whether source is a URL rather than a file path.
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Fetches the PAC file from a URL.
+func (u *Upstream) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxUpstreamSize))
+}
+
+// Wraps the upstream PAC file so that its FindProxyForURL and any helpers
+// it defines don't clash with ours, and makes its FindProxyForURL available
+// as upstreamFindProxyForURL.
+func wrapUpstream(script string) string {
+	return "var upstreamFindProxyForURL = (function () {\n" + script + "\nreturn FindProxyForURL;\n})();\n\n"
+}
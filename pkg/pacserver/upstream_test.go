@@ -0,0 +1,120 @@
+package pacserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testUpstream = "function FindProxyForURL(url, host) { return 'PROXY corp:3128'; }"
+
+func TestUpstream_Load(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	body := testUpstream
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "corp.pac")
+	err := os.WriteFile(file, []byte(testUpstream), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	for _, source := range []string{server.URL, file} {
+		u, err := NewUpstream(logger, source, time.Hour)
+		if err != nil {
+			t.Fatalf("NewUpstream() error = %v", err)
+		}
+		err = u.Load(context.Background())
+		if err != nil {
+			t.Fatalf("Load(%s) error = %v", source, err)
+		}
+		if got := u.Script(); got != testUpstream {
+			t.Errorf("Script() after loading %s = %q, want %q", source, got, testUpstream)
+		}
+	}
+
+	// An invalid file does not replace the previous one
+	u, err := NewUpstream(logger, server.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("NewUpstream() error = %v", err)
+	}
+	err = u.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	body = "<html>Login required</html>"
+	err = u.Load(context.Background())
+	if err == nil {
+		t.Error("Load() of invalid PAC file succeeded, want error")
+	}
+	if got := u.Script(); got != testUpstream {
+		t.Errorf("Script() after failed load = %q, want %q", got, testUpstream)
+	}
+}
+
+func TestNewUpstream_validation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, source := range []string{"", "http://", "https://%zz/wpad.dat"} {
+		_, err := NewUpstream(logger, source, time.Hour)
+		if err == nil {
+			t.Errorf("NewUpstream(%q) succeeded, want error", source)
+		}
+	}
+}
+
+func TestUpstream_Run(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Not reachable at first, like off VPN
+	var mu sync.Mutex
+	reachable := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reachable {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = io.WriteString(w, testUpstream)
+	}))
+	defer server.Close()
+
+	u, err := NewUpstream(logger, server.URL, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewUpstream() error = %v", err)
+	}
+	err = u.Load(context.Background())
+	if err == nil {
+		t.Fatal("Load() of unreachable PAC file succeeded, want error")
+	}
+	if got := u.Script(); got != "" {
+		t.Errorf("Script() = %q, want none", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Run(ctx)
+
+	mu.Lock()
+	reachable = true
+	mu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for u.Script() != testUpstream {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream PAC file not loaded by refresh")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
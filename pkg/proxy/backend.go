@@ -0,0 +1,20 @@
+package proxy
+
+// TunnelBackend discovers the nodes of an installation and opens tunnels to
+// them. Each tunnel serves SOCKS5 on a local port.
+type TunnelBackend interface {
+	// Nodes returns the names of the nodes a tunnel can be opened to.
+	Nodes() ([]string, error)
+	// Open opens a tunnel to the given node, serving SOCKS5 on the given
+	// local port.
+	Open(node string, port int) (Tunnel, error)
+}
+
+// Tunnel is a tunnel opened by a TunnelBackend.
+type Tunnel interface {
+	// Wait blocks until the tunnel has exited and returns the reason.
+	// It may be called multiple times and from multiple goroutines.
+	Wait() error
+	// Close terminates the tunnel.
+	Close() error
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of events buffered per subscriber. Events for subscribers that
+// fall further behind are dropped.
+const subscriberBufferSize = 64
+
+// EventInfo holds the fields common to all events.
+type EventInfo struct {
+	// Name of the proxy that published the event
+	Proxy string
+	// When the event happened
+	Time time.Time
+}
+
+// Info returns the common fields of the event.
+func (i EventInfo) Info() EventInfo {
+	return i
+}
+
+// Event is published by a proxy when something about it changes. Use a type
+// switch to tell the concrete event types apart.
+type Event interface {
+	Info() EventInfo
+}
+
+// StateChangedEvent is published when the proxy moves to another state.
+type StateChangedEvent struct {
+	EventInfo
+	Previous State
+	State    State
+	Reason   string
+}
+
+// NodeSwitchedEvent is published when the proxy selects a different node
+// for its tunnel.
+type NodeSwitchedEvent struct {
+	EventInfo
+	// Previously used node, may be empty
+	Previous string
+	Node     string
+}
+
+// NodesChangedEvent is published when the list of available nodes changed.
+type NodesChangedEvent struct {
+	EventInfo
+	Added   []string
+	Removed []string
+	Nodes   []string
+}
+
+// ProcessExitedEvent is published when the tunnel exited unexpectedly.
+type ProcessExitedEvent struct {
+	EventInfo
+	Node string
+	Err  error
+}
+
+// RestartScheduledEvent is published when the supervisor schedules the
+// restart of an exited tunnel.
+type RestartScheduledEvent struct {
+	EventInfo
+	// Delay before the restart
+	Delay time.Duration
+	// Number of consecutive restart attempts, starting at 1
+	Attempt int
+}
+
+// PingResultEvent is published after each health check.
+type PingResultEvent struct {
+	EventInfo
+	Success    bool
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+// subscribers manages the event channels and observers of a proxy.
+type subscribers struct {
+	mu       sync.Mutex
+	channels map[<-chan Event]chan Event
+	// Functions called for every event, keyed by registration
+	observers map[int]func(Event)
+	next      int
+}
+
+// Subscribe returns a channel receiving all events the proxy publishes from
+// now on. Events are dropped if the channel is full, so consumers should
+// read it continuously. Call Unsubscribe when done.
+func (p *Proxy) Subscribe() <-chan Event {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	if p.subscribers.channels == nil {
+		p.subscribers.channels = map[<-chan Event]chan Event{}
+	}
+	ch := make(chan Event, subscriberBufferSize)
+	p.subscribers.channels[ch] = ch
+
+	return ch
+}
+
+// Unsubscribe stops delivering events to a channel returned by Subscribe
+// and closes it.
+func (p *Proxy) Unsubscribe(ch <-chan Event) {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	if c, ok := p.subscribers.channels[ch]; ok {
+		delete(p.subscribers.channels, ch)
+		close(c)
+	}
+}
+
+// Observe calls fn for every event the proxy publishes from now on. Unlike
+// Subscribe no event is ever dropped, but fn is called synchronously while
+// the proxy is locked, so it must return quickly and must not call methods
+// of the proxy. The returned function stops the calls.
+func (p *Proxy) Observe(fn func(Event)) func() {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	if p.subscribers.observers == nil {
+		p.subscribers.observers = map[int]func(Event){}
+	}
+	id := p.subscribers.next
+	p.subscribers.next++
+	p.subscribers.observers[id] = fn
+
+	return func() {
+		p.subscribers.mu.Lock()
+		defer p.subscribers.mu.Unlock()
+
+		delete(p.subscribers.observers, id)
+	}
+}
+
+// Delivers an event to all observers and, without blocking, to all
+// subscribers. May be called with p.mu held.
+func (p *Proxy) publish(e Event) {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	for _, fn := range p.subscribers.observers {
+		fn(e)
+	}
+	for _, ch := range p.subscribers.channels {
+		select {
+		case ch <- e:
+		default:
+			// Subscriber is not keeping up
+		}
+	}
+}
+
+// Returns the common event fields for an event happening now.
+func (p *Proxy) eventInfo() EventInfo {
+	return EventInfo{Proxy: p.Name, Time: time.Now()}
+}
+
+// SubscribeAll subscribes to the events of all given proxies and merges them
+// into one channel. The returned function unsubscribes from all proxies and
+// closes the channel.
+func SubscribeAll(proxies []*Proxy) (<-chan Event, func()) {
+	merged := make(chan Event, subscriberBufferSize)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, p := range proxies {
+		ch := p.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case e, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case merged <- e:
+					case <-done:
+						p.Unsubscribe(ch)
+						return
+					}
+				case <-done:
+					p.Unsubscribe(ch)
+					return
+				}
+			}
+		}()
+	}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			close(merged)
+		})
+	}
+
+	return merged, unsubscribe
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Reads events from ch until one of type T arrives.
+func waitForEvent[T Event](t *testing.T, ch <-chan Event) T {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatal("event channel closed")
+			}
+			if event, ok := e.(T); ok {
+				return event
+			}
+		case <-timeout:
+			var zero T
+			t.Fatalf("timed out waiting for %T", zero)
+		}
+	}
+}
+
+func TestProxy_Subscribe(t *testing.T) {
+	restartBackoffMin = time.Millisecond
+	defer func() { restartBackoffMin = time.Second }()
+
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events := p.Subscribe()
+
+	exitErr := errors.New("connection reset")
+	backend.tunnels()[0].exit(exitErr)
+
+	exited := waitForEvent[ProcessExitedEvent](t, events)
+	if exited.Proxy != "test" || !errors.Is(exited.Err, exitErr) {
+		t.Errorf("ProcessExitedEvent = %+v, want proxy test with error %v", exited, exitErr)
+	}
+	changed := waitForEvent[StateChangedEvent](t, events)
+	if changed.State != StateRestarting {
+		t.Errorf("StateChangedEvent.State = %v, want %v", changed.State, StateRestarting)
+	}
+	scheduled := waitForEvent[RestartScheduledEvent](t, events)
+	if scheduled.Attempt != 1 {
+		t.Errorf("RestartScheduledEvent.Attempt = %d, want 1", scheduled.Attempt)
+	}
+	switched := waitForEvent[NodeSwitchedEvent](t, events)
+	if switched.Previous == switched.Node {
+		t.Errorf("NodeSwitchedEvent = %+v, want different nodes", switched)
+	}
+	changed = waitForEvent[StateChangedEvent](t, events)
+	if changed.State != StateConnecting {
+		t.Errorf("StateChangedEvent.State = %v, want %v", changed.State, StateConnecting)
+	}
+
+	p.Unsubscribe(events)
+	for range events {
+		// Drain until closed
+	}
+
+	// Publishing without subscribers or to a full channel must not block
+	slow := p.Subscribe()
+	for range subscriberBufferSize + 1 {
+		p.publish(PingResultEvent{EventInfo: p.eventInfo()})
+	}
+	p.Unsubscribe(slow)
+}
+
+func TestSubscribeAll(t *testing.T) {
+	backendA := &fakeBackend{}
+	a, err := New(testLogger(), "a", "a.example.com", testCheck, backendA)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	backendB := &fakeBackend{}
+	b, err := New(testLogger(), "b", "b.example.com", testCheck, backendB)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events, unsubscribe := SubscribeAll([]*Proxy{a, b})
+
+	backendB.setNodes("node-b")
+	b.refreshNodes()
+	e := waitForEvent[NodesChangedEvent](t, events)
+	if e.Proxy != "b" || len(e.Added) != 1 {
+		t.Errorf("NodesChangedEvent = %+v, want node added to proxy b", e)
+	}
+
+	unsubscribe()
+	unsubscribe() // must be safe to call twice
+	for range events {
+		// Drain until closed
+	}
+}
+
+func TestProxy_Observe(t *testing.T) {
+	p, err := New(testLogger(), "test", "example.com", testCheck, &fakeBackend{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Unlike subscribers, observers never miss an event
+	var observed int
+	stop := p.Observe(func(e Event) {
+		if _, ok := e.(PingResultEvent); ok {
+			observed++
+		}
+	})
+	for range subscriberBufferSize + 1 {
+		p.publish(PingResultEvent{EventInfo: p.eventInfo()})
+	}
+	if observed != subscriberBufferSize+1 {
+		t.Errorf("observed %d events, want %d", observed, subscriberBufferSize+1)
+	}
+
+	stop()
+	p.publish(PingResultEvent{EventInfo: p.eventInfo()})
+	if observed != subscriberBufferSize+1 {
+		t.Errorf("observed %d events after stop, want %d", observed, subscriberBufferSize+1)
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Maximum number of response body bytes searched for HealthCheck.BodyMatch.
+const maxCheckBodySize = 1 << 20
+
+var (
+	// ErrUnexpectedStatus means the health check response had a status code
+	// that is not considered healthy.
+	ErrUnexpectedStatus = errors.New("unexpected status code")
+	// ErrBodyMismatch means the health check response body did not match
+	// HealthCheck.BodyMatch.
+	ErrBodyMismatch = errors.New("response body does not match")
+)
+
+// HealthCheck defines how a proxy checks that its installation is reachable
+// through the tunnel.
+type HealthCheck struct {
+	// URL to request. If it has no scheme, https:// is assumed.
+	URL string
+	// HTTP method, GET if empty.
+	Method string
+	// Status codes considered healthy. If empty, any status below 500 is.
+	ExpectedStatus []int
+	// If set, the response body must match this expression.
+	BodyMatch *regexp.Regexp
+	// Timeout for a single check. Defaults to 10 seconds.
+	Timeout time.Duration
+	// Time between checks. Defaults to 30 seconds.
+	Interval time.Duration
+}
+
+// Returns a copy of the check with defaults applied.
+func (c HealthCheck) withDefaults() HealthCheck {
+	if !hasScheme(c.URL) {
+		c.URL = "https://" + c.URL
+	}
+	if c.Method == "" {
+		c.Method = http.MethodGet
+	}
+	if c.Timeout == 0 {
+		c.Timeout = pingTimeout
+	}
+	if c.Interval == 0 {
+		c.Interval = pingInterval
+	}
+	return c
+}
+
+// Returns an error describing why the response does not pass the check, or
+// nil if it does.
+func (c HealthCheck) evaluate(resp *http.Response) error {
+	if len(c.ExpectedStatus) > 0 {
+		if !slices.Contains(c.ExpectedStatus, resp.StatusCode) {
+			return fmt.Errorf("%w %d, want one of %v", ErrUnexpectedStatus, resp.StatusCode, c.ExpectedStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 500 {
+		return fmt.Errorf("%w %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	if c.BodyMatch == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if !c.BodyMatch.Match(body) {
+		return fmt.Errorf("%w %q", ErrBodyMismatch, c.BodyMatch.String())
+	}
+
+	return nil
+}
+
+// hasScheme checks if the URL has a scheme (http:// or https://)
+func hasScheme(url string) bool {
+	return len(url) > 7 && (url[:7] == "http://" || url[:8] == "https://")
+}
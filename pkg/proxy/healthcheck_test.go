@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHealthCheck_evaluate(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   HealthCheck
+		status  int
+		body    string
+		wantErr error
+	}{
+		{name: "default accepts 2xx", status: 200},
+		{name: "default accepts 4xx", status: 401},
+		{name: "default rejects 5xx", status: 503, wantErr: ErrUnexpectedStatus},
+		{name: "default rejects 1xx", status: 101, wantErr: ErrUnexpectedStatus},
+		{
+			name:   "expected status matches",
+			check:  HealthCheck{ExpectedStatus: []int{200, 204}},
+			status: 204,
+		},
+		{
+			name:    "expected status does not match",
+			check:   HealthCheck{ExpectedStatus: []int{200}},
+			status:  401,
+			wantErr: ErrUnexpectedStatus,
+		},
+		{
+			name:   "body matches",
+			check:  HealthCheck{BodyMatch: regexp.MustCompile(`"status":\s*"ok"`)},
+			status: 200,
+			body:   `{"status": "ok"}`,
+		},
+		{
+			name:    "body does not match",
+			check:   HealthCheck{BodyMatch: regexp.MustCompile(`"status":\s*"ok"`)},
+			status:  200,
+			body:    `{"status": "degraded"}`,
+			wantErr: ErrBodyMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			err := tt.check.evaluate(resp)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthCheck_withDefaults(t *testing.T) {
+	c := HealthCheck{URL: "happaapi.example.com/healthz"}.withDefaults()
+	if c.URL != "https://happaapi.example.com/healthz" {
+		t.Errorf("URL = %q, want https:// prefix", c.URL)
+	}
+	if c.Method != http.MethodGet {
+		t.Errorf("Method = %q, want %q", c.Method, http.MethodGet)
+	}
+	if c.Timeout != pingTimeout || c.Interval != pingInterval {
+		t.Errorf("Timeout, Interval = %v, %v, want %v, %v", c.Timeout, c.Interval, pingTimeout, pingInterval)
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Hosts defines which host names a proxy is used for besides its Domain and
+// the subdomains of it.
+type Hosts struct {
+	// Additional domains, including their subdomains
+	Domains []string
+	// Host name patterns using the wildcards * and ?, like shExpMatch in
+	// PAC files
+	Patterns []string
+	// Patterns of host names that must not use the proxy, even if they match
+	// otherwise
+	Exclude []string
+	// IP ranges, for clients connecting to IP addresses
+	Networks []*net.IPNet
+
+	// Patterns and Exclude compiled, nil until compile is called
+	patterns []*regexp.Regexp
+	exclude  []*regexp.Regexp
+}
+
+// Returns the hosts with the patterns compiled, so that matching doesn't
+// compile them on every connection.
+func (h Hosts) compile() Hosts {
+	if h.patterns != nil && h.exclude != nil {
+		return h
+	}
+
+	h.patterns = make([]*regexp.Regexp, 0, len(h.Patterns))
+	for _, pattern := range h.Patterns {
+		h.patterns = append(h.patterns, compileShExp(pattern))
+	}
+	h.exclude = make([]*regexp.Regexp, 0, len(h.Exclude))
+	for _, pattern := range h.Exclude {
+		h.exclude = append(h.exclude, compileShExp(pattern))
+	}
+	return h
+}
+
+// SetHosts replaces the further hosts the proxy is used for. Unlike setting
+// Hosts directly, it is safe while front-ends use the proxy.
+func (p *Proxy) SetHosts(hosts Hosts) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Hosts = hosts.compile()
+}
+
+// Matches reports whether the proxy is responsible for the given host name.
+// The proxy domain, Domains and Patterns are matched case-insensitively,
+// unless the host matches one of the Exclude patterns.
+func (p *Proxy) Matches(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	p.mu.Lock()
+	hosts := p.Hosts
+	p.mu.Unlock()
+	// Hosts set directly instead of with SetHosts are not compiled yet
+	hosts = hosts.compile()
+
+	for _, pattern := range hosts.exclude {
+		if pattern.MatchString(host) {
+			return false
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range hosts.Networks {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if matchDomain(host, p.Domain) {
+		return true
+	}
+	for _, domain := range hosts.Domains {
+		if matchDomain(host, domain) {
+			return true
+		}
+	}
+	for _, pattern := range hosts.patterns {
+		if pattern.MatchString(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns whether host is the domain itself or one of its subdomains. The
+// host must be lowercase already.
+func matchDomain(host, domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// Compiles a shell expression, where * matches any sequence of characters
+// and ? matches a single character, to match lowercase host names like
+// shExpMatch in PAC files.
+func compileShExp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(strings.ToLower(pattern))
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^" + expr + "$")
+}
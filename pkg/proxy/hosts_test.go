@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestProxy_Matches(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/16")
+	p := &Proxy{Name: "test", Domain: "example.com"}
+	p.SetHosts(Hosts{
+		Domains:  []string{"internal.example.org"},
+		Patterns: []string{"grafana-*.example.net"},
+		Exclude:  []string{"public.example.com", "*.public.example.com"},
+		Networks: []*net.IPNet{network},
+	})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "example.com", want: true},
+		{host: "happa.example.com", want: true},
+		{host: "Happa.Example.COM.", want: true},
+		{host: "a.b.example.com", want: true},
+		{host: "notexample.com", want: false},
+		{host: "example.com.evil.org", want: false},
+		{host: "192.0.2.1", want: false},
+		{host: "internal.example.org", want: true},
+		{host: "api.internal.example.org", want: true},
+		{host: "grafana-eu.example.net", want: true},
+		{host: "grafana.example.net", want: false},
+		{host: "public.example.com", want: false},
+		{host: "www.public.example.com", want: false},
+		{host: "10.0.3.4", want: true},
+		{host: "10.1.0.1", want: false},
+	}
+	for _, tt := range tests {
+		if got := p.Matches(tt.host); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestProxy_SetHosts(t *testing.T) {
+	p := &Proxy{Name: "test", Domain: "example.com"}
+	p.SetHosts(Hosts{Patterns: []string{"grafana-*.example.net"}, Exclude: []string{"public.example.com"}})
+
+	// Compiled once, not on every match
+	if len(p.Hosts.patterns) != 1 || len(p.Hosts.exclude) != 1 {
+		t.Fatalf("SetHosts() did not compile the patterns: %+v", p.Hosts)
+	}
+	if !p.Matches("grafana-eu.example.net") || p.Matches("public.example.com") {
+		t.Error("Matches() does not use the new hosts")
+	}
+
+	// Matching leaves hosts set directly alone
+	p.Hosts = Hosts{Patterns: []string{"grafana-*.example.net"}}
+	if !p.Matches("grafana-eu.example.net") {
+		t.Error("Matches() does not use hosts set directly")
+	}
+	if p.Hosts.patterns != nil {
+		t.Error("Matches() changed the hosts of the proxy")
+	}
+}
+
+func Test_compileShExp(t *testing.T) {
+	tests := []struct {
+		host    string
+		pattern string
+		want    bool
+	}{
+		{host: "a.example.com", pattern: "*.example.com", want: true},
+		{host: "example.com", pattern: "*.example.com", want: false},
+		{host: "node1.example.com", pattern: "node?.example.com", want: true},
+		{host: "node12.example.com", pattern: "node?.example.com", want: false},
+		{host: "axexample.com", pattern: "a.example.com", want: false},
+		{host: "api.example.com", pattern: "API.example.com", want: true},
+	}
+	for _, tt := range tests {
+		if got := compileShExp(tt.pattern).MatchString(tt.host); got != tt.want {
+			t.Errorf("compileShExp(%q) matches %q = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
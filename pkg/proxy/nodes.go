@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+)
+
+var (
+	// Time between node list refreshes.
+	nodeRefreshInterval = 5 * time.Minute
+	// Number of consecutive failed pings after which the node list is
+	// refreshed before restarting the tunnel.
+	failuresBeforeRefresh = 2
+)
+
+// Fetches the current node list from the backend. If the active node is no
+// longer listed, the tunnel is moved to another node. If the proxy had no
+// nodes before, the tunnel is started. On errors, the previous list is kept.
+func (p *Proxy) refreshNodes() {
+	nodes, err := p.backend.Nodes()
+	if err != nil {
+		attrs := []any{slog.String("name", p.Name), slog.String("error", err.Error())}
+		if r := tsherr.Remediation(err); r != "" {
+			attrs = append(attrs, slog.String("remediation", r))
+		}
+		p.logger.Warn("Failed to refresh nodes", attrs...)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	added, removed := diffNodes(p.nodes, nodes)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	p.logger.Info("Nodes changed", slog.String("name", p.Name), slog.Int("count", len(nodes)), slog.String("added", strings.Join(added, ", ")), slog.String("removed", strings.Join(removed, ", ")))
+	p.nodes = nodes
+	p.publish(NodesChangedEvent{EventInfo: p.eventInfo(), Added: added, Removed: removed, Nodes: slices.Clone(nodes)})
+
+	if p.state == StateStopped || p.state == StateRestarting || p.state == StateAuthExpired || p.state == StateFailed {
+		return
+	}
+
+	if p.tunnel != nil && slices.Contains(nodes, p.nodeActive) {
+		return // Active node still available
+	}
+
+	if p.tunnel != nil {
+		p.logger.Info("Active node was removed, switching node", slog.String("name", p.Name), slog.String("node", p.nodeActive))
+		err = p.stop()
+		if err != nil {
+			p.logger.Error("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+		}
+	}
+
+	if len(nodes) == 0 {
+		p.nodeActive = ""
+		p.setState(StateNoNodes, "all nodes were removed")
+		return
+	}
+
+	p.selectNode()
+	err = p.start()
+	if err != nil {
+		p.logger.Error("Failed to start proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+	}
+}
+
+// Returns the nodes only in after, and the ones only in before.
+func diffNodes(before, after []string) (added, removed []string) {
+	for _, n := range after {
+		if !slices.Contains(before, n) {
+			added = append(added, n)
+		}
+	}
+	for _, n := range before {
+		if !slices.Contains(after, n) {
+			removed = append(removed, n)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestProxy_refreshNodes(t *testing.T) {
+	t.Run("starts tunnel once nodes appear", func(t *testing.T) {
+		backend := &fakeBackend{}
+		p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if got := len(backend.tunnels()); got != 0 {
+			t.Fatalf("tunnels opened without nodes = %d, want 0", got)
+		}
+
+		backend.setNodes("node-a")
+		p.refreshNodes()
+
+		tunnels := backend.tunnels()
+		if len(tunnels) != 1 || tunnels[0].node != "node-a" {
+			t.Fatalf("tunnels = %v, want one tunnel to node-a", tunnels)
+		}
+		if status := p.Status(); status.NodeCount != 1 || status.ActiveNode != "node-a" {
+			t.Errorf("NodeCount, ActiveNode = %d, %q, want 1, %q", status.NodeCount, status.ActiveNode, "node-a")
+		}
+	})
+
+	t.Run("moves tunnel when active node is removed", func(t *testing.T) {
+		backend := &fakeBackend{nodes: []string{"node-a"}}
+		p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		backend.setNodes("node-b", "node-c")
+		p.refreshNodes()
+
+		tunnels := backend.tunnels()
+		if len(tunnels) != 2 {
+			t.Fatalf("tunnels opened = %d, want 2", len(tunnels))
+		}
+		if !tunnels[0].closed() {
+			t.Error("tunnel to removed node was not closed")
+		}
+		if tunnels[1].node == "node-a" {
+			t.Error("new tunnel uses removed node")
+		}
+	})
+
+	t.Run("keeps tunnel when active node remains", func(t *testing.T) {
+		backend := &fakeBackend{nodes: []string{"node-a"}}
+		p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		backend.setNodes("node-a", "node-b")
+		p.refreshNodes()
+
+		if got := len(backend.tunnels()); got != 1 {
+			t.Errorf("tunnels opened = %d, want 1", got)
+		}
+		if got := p.Status().Nodes; !slices.Equal(got, []string{"node-a", "node-b"}) {
+			t.Errorf("Nodes = %v, want [node-a node-b]", got)
+		}
+	})
+
+	t.Run("keeps nodes on error", func(t *testing.T) {
+		backend := &fakeBackend{nodes: []string{"node-a"}}
+		p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		backend.mu.Lock()
+		backend.nodesErr = errors.New("network unreachable")
+		backend.mu.Unlock()
+		p.refreshNodes()
+
+		if got := p.Status().NodeCount; got != 1 {
+			t.Errorf("NodeCount = %d, want 1", got)
+		}
+	})
+}
+
+func Test_diffNodes(t *testing.T) {
+	added, removed := diffNodes([]string{"a", "b"}, []string{"b", "c"})
+	if !slices.Equal(added, []string{"c"}) {
+		t.Errorf("added = %v, want [c]", added)
+	}
+	if !slices.Equal(removed, []string{"a"}) {
+		t.Errorf("removed = %v, want [a]", removed)
+	}
+}
@@ -1,26 +1,32 @@
 // Package proxy configures a SOCKS5 proxy that is actually an SSH tunnel.
 // A proxy is used for one domain only and should have a unique port. There
 // is self-checking logic to ensure that the proxy is running and reachable.
+// How tunnels are opened is up to the TunnelBackend, TshBackend being the
+// default one.
 package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	rand "math/rand/v2"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/net/proxy"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
 )
 
 var (
-	startPort = 1080
+	// Port of the first tunnel. Port 1080 is left for the SOCKS5 front-end
+	// clients connect to.
+	startPort = 1081
 
 	pingTimeout  = 10 * time.Second
 	pingInterval = 30 * time.Second
@@ -28,6 +34,21 @@ var (
 	proxyHost = "localhost"
 )
 
+// SetStartPort sets the port the tunnel of the next proxy listens on. The
+// proxies created after it get the ports following it. Proxies start at
+// port 1081 by default.
+func SetStartPort(port int) {
+	startPort = port
+}
+
+var (
+	// ErrStopped is returned when acting on a proxy that was stopped.
+	ErrStopped = errors.New("proxy is stopped")
+	// ErrUnknownNode is returned when switching to a node the proxy does
+	// not know.
+	ErrUnknownNode = errors.New("unknown node")
+)
+
 type pingResult struct {
 	success    bool
 	statusCode int
@@ -42,80 +63,116 @@ type Proxy struct {
 	Port int
 	// Domain the proxy should be used for.
 	Domain string
-	// CheckEndpoint is the endpoint to ping for this proxy
-	CheckEndpoint string
+	// Further hosts the proxy should be used for. Use SetHosts to set them,
+	// which is safe while front-ends use the proxy and compiles the
+	// patterns once.
+	Hosts Hosts
+	// Check defines how to ping this proxy. Use SetCheck to change it once
+	// the proxy is running.
+	Check HealthCheck
 
 	// List of Teleport node names available for this proxy.
 	// Only one will be used.
 	nodes []string
 	// The node actually used for the SSH tunnel
 	nodeActive string
-	// SSH tunnel Teleport process
-	process *os.Process
-	// Healthy determines if the proxy is healthy
-	healthy bool
+	// Backend used to discover nodes and open tunnels
+	backend TunnelBackend
+	// The currently open tunnel
+	tunnel Tunnel
+	// When the current tunnel was opened, zero if there is none
+	tunnelSince time.Time
+	// Current lifecycle state
+	state State
+	// Why the proxy is in its current state
+	stateReason string
+	// When the proxy entered its current state
+	stateSince time.Time
 	// Last ping result
 	lastPingResult *pingResult
+	// Number of consecutive unexpected tunnel exits, used for backoff
+	exits int
+	// Total number of tunnel restarts after unexpected exits
+	restarts int
+	// Error the last tunnel exited with
+	lastExitErr error
+	// Number of consecutive failed pings
+	failures int
+	// Guards the tunnel, nodes and state, which are shared between the
+	// ping, supervisor and display goroutines
+	mu sync.Mutex
+	// Channels receiving events
+	subscribers subscribers
+	// Closed by Stop to abort pending restarts, replaced by Start
+	stopCh chan struct{}
+	// Whether the PingConstantly loop is running, ensures there is only
+	// one pinger per proxy
+	pinging bool
 
 	// Logger
 	logger *slog.Logger
 	// Pinger
 	pinger *http.Client
-	// Ensure there is only one pinger per proxy
-	pingerMu sync.Mutex
 }
 
-func New(logger *slog.Logger, name string, domain string, checkEndpoint string) (*Proxy, error) {
+func New(logger *slog.Logger, name string, domain string, check HealthCheck, backend TunnelBackend) (*Proxy, error) {
 	if name == "" {
 		return nil, fmt.Errorf("name must not be empty")
 	}
 	if domain == "" {
 		return nil, fmt.Errorf("domain must not be empty")
 	}
-	if checkEndpoint == "" {
-		return nil, fmt.Errorf("checkEndpoint must not be empty")
+	if check.URL == "" {
+		return nil, fmt.Errorf("check URL must not be empty")
+	}
+	if backend == nil {
+		return nil, fmt.Errorf("backend must not be nil")
 	}
 
 	port := startPort
 	startPort++ // Increment the port for the next proxy
 
-	// Selector for command `tsh ls --format=names ins=MC_NAME,cluster=MC_NAME,role=control-plane`
-	selector := fmt.Sprintf("ins=%s,cluster=%s,role=control-plane", name, name)
-
-	nodes, err := getNodes(selector)
+	nodes, err := backend.Nodes()
 	if err != nil {
-		logger.Error("Failed to get nodes for installation", slog.String("selector", selector), slog.String("name", name), slog.String("domain", domain), slog.String("error", err.Error()))
+		attrs := []any{slog.String("name", name), slog.String("domain", domain), slog.String("error", err.Error())}
+		if r := tsherr.Remediation(err); r != "" {
+			attrs = append(attrs, slog.String("remediation", r))
+		}
+		logger.Error("Failed to get nodes for installation", attrs...)
 	}
 	if len(nodes) == 0 {
-		logger.Error("No nodes found for installation", slog.String("selector", selector), slog.String("name", name), slog.String("domain", domain))
+		logger.Error("No nodes found for installation", slog.String("name", name), slog.String("domain", domain))
 	}
 
-	logger.Debug("Nodes for installation", slog.String("selector", selector), slog.Int("count", len(nodes)), slog.String("name", name), slog.String("nodes", strings.Join(nodes, ", ")))
-
-	pinger, err := newPinger(port)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create pinger for proxy %s: %v", name, err)
-	}
+	logger.Debug("Nodes for installation", slog.Int("count", len(nodes)), slog.String("name", name), slog.String("nodes", strings.Join(nodes, ", ")))
 
 	p := &Proxy{
-		Name:          name,
-		Port:          port,
-		Domain:        domain,
-		CheckEndpoint: checkEndpoint,
-
-		nodes:  nodes,
-		logger: logger,
-		pinger: pinger,
+		Name:   name,
+		Port:   port,
+		Domain: domain,
+		Check:  check.withDefaults(),
+
+		nodes:      nodes,
+		backend:    backend,
+		state:      StateStarting,
+		stateSince: time.Now(),
+		stopCh:     make(chan struct{}),
+		logger:     logger,
+		pinger:     newPinger(port, check.withDefaults().Timeout),
 	}
 
 	_ = p.selectNode()
-	_ = p.Start()
+	err = p.Start()
+	if err != nil {
+		logger.Error("Failed to start proxy", slog.String("name", name), slog.String("error", err.Error()))
+	}
 
 	return p, nil
 }
 
 // Selects the node to use for the SSH tunnel.
 // If a node was previously selected, a different one will be chosen if possible.
+// Must be called with p.mu held.
 func (p *Proxy) selectNode() string {
 	if len(p.nodes) == 0 {
 		return ""
@@ -129,6 +186,7 @@ func (p *Proxy) selectNode() string {
 
 	for i := range p.nodes {
 		if p.nodeActive != p.nodes[i] {
+			p.publish(NodeSwitchedEvent{EventInfo: p.eventInfo(), Previous: p.nodeActive, Node: p.nodes[i]})
 			p.nodeActive = p.nodes[i]
 			p.logger.Debug("Selected new node for proxy", slog.String("name", p.Name), slog.String("domain", p.Domain), slog.String("node", p.nodeActive))
 			return p.nodeActive
@@ -139,237 +197,446 @@ func (p *Proxy) selectNode() string {
 	return p.nodes[0]
 }
 
-// Start creates the SSH tunnel and thus starts the proxy.
+// Start creates the SSH tunnel and thus starts the proxy. It does nothing
+// if the tunnel is already open.
 func (p *Proxy) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == StateStopped {
+		p.stopCh = make(chan struct{})
+	}
+	return p.start()
+}
+
+// Opens the tunnel and starts supervising it, unless it is open already.
+// Must be called with p.mu held.
+func (p *Proxy) start() error {
+	if p.tunnel != nil {
+		return nil // Already running
+	}
+
 	if len(p.nodes) == 0 {
+		p.setState(StateNoNodes, "no nodes found")
 		return fmt.Errorf("failed to start proxy for %s: no nodes available", p.Name)
 	}
 
-	// Pick a random node
-	node := p.nodes[rand.IntN(len(p.nodes))] //nolint:gosec
+	node := p.nodeActive
+	if node == "" {
+		node = p.selectNode()
+	}
 
 	p.logger.Info("Starting proxy", slog.String("name", p.Name), slog.String("domain", p.Domain), slog.String("node", node), slog.Int("port", p.Port))
-	host := fmt.Sprintf("root@node=%s,ins=%s", node, p.Name)
-	cmd := exec.Command("tsh", "ssh", "--no-remote-exec", "--dynamic-forward", fmt.Sprintf("%d", p.Port), host) //nolint:gosec
 
-	err := cmd.Start()
+	tunnel, err := p.backend.Open(node, p.Port)
 	if err != nil {
-		return fmt.Errorf("failed to start proxy for %s: %v", p.Name, err)
+		if !p.failFor(err) {
+			p.setState(StateDegraded, fmt.Sprintf("failed to open tunnel: %v", err))
+		}
+		return fmt.Errorf("failed to start proxy for %s: %w", p.Name, err)
 	}
 
-	p.process = cmd.Process
-	p.nodeActive = node
+	p.tunnel = tunnel
+	p.tunnelSince = time.Now()
+	p.setState(StateConnecting, fmt.Sprintf("tunnel opened to node %s", node))
+	go p.supervise(tunnel, p.stopCh)
 
 	return nil
 }
 
-func (p *Proxy) PingConstantly() {
-	p.pingerMu.Lock()
-	defer p.pingerMu.Unlock()
-	ctx := context.Background()
+// Closes the tunnel and opens a new one to a different node.
+func (p *Proxy) restart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == StateStopped || p.state == StateRestarting || p.state == StateAuthExpired || p.state == StateFailed {
+		return nil // Stopped on purpose or for good, or someone else will restart it
+	}
+
+	err := p.stop()
+	if err != nil {
+		p.logger.Error("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+	}
+	p.setState(StateRestarting, "health check failed")
+	p.selectNode()
+
+	return p.start()
+}
+
+// Restart closes the tunnel and opens a new one to the same node.
+func (p *Proxy) Restart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.reopen("restart requested")
+}
+
+// SwitchNode moves the tunnel to the given node. If node is empty, a
+// different node is picked at random if there is one.
+func (p *Proxy) SwitchNode(node string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == StateStopped {
+		return ErrStopped
+	}
+
+	switch {
+	case node == "":
+		p.selectNode()
+	case !slices.Contains(p.nodes, node):
+		return fmt.Errorf("%w %q", ErrUnknownNode, node)
+	case node != p.nodeActive:
+		p.publish(NodeSwitchedEvent{EventInfo: p.eventInfo(), Previous: p.nodeActive, Node: node})
+		p.nodeActive = node
+	}
+
+	return p.reopen(fmt.Sprintf("switch to node %s requested", p.nodeActive))
+}
+
+// Closes the tunnel and opens a new one to the active node on request.
+// Must be called with p.mu held.
+func (p *Proxy) reopen(reason string) error {
+	if p.state == StateStopped {
+		return ErrStopped
+	}
+
+	err := p.stop()
+	if err != nil {
+		return err
+	}
+	p.setState(StateRestarting, reason)
+
+	return p.start()
+}
+
+// PingConstantly checks the proxy in the background, restarting the tunnel
+// when checks fail, and keeps the node list up to date. When ctx is
+// cancelled, the loop ends and the proxy is stopped. Calling it while the
+// loop is running already has no effect.
+func (p *Proxy) PingConstantly(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pinging {
+		return
+	}
+	p.pinging = true
+
 	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.pinging = false
+			p.mu.Unlock()
+
+			err := p.Stop()
+			if err != nil {
+				p.logger.Error("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+			}
+		}()
+
 		// Do an initial ping immediately after a short delay for the tunnel to establish
-		time.Sleep(2 * time.Second)
-		if len(p.nodes) > 0 {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+		if p.NodeCount() > 0 {
 			p.Ping(ctx)
 		}
 
-		ticker := time.NewTicker(pingInterval)
+		interval := p.checkInterval()
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
+		refreshTicker := time.NewTicker(nodeRefreshInterval)
+		defer refreshTicker.Stop()
+
 		for {
 			select {
 			case <-ticker.C:
-				// TODO: Handle case where no nodes are available
-				if len(p.nodes) > 0 {
-					success := p.Ping(ctx)
-					if !success {
-						p.logger.Debug("Restarting proxy with different node", slog.String("name", p.Name))
-						err := p.Stop()
-						if err != nil {
-							p.logger.Error("Failed to stop proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
-						}
-						p.selectNode()
-						err = p.Start()
-						if err != nil {
-							p.logger.Error("Failed to restart proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
-						}
-					}
+				if i := p.checkInterval(); i != interval {
+					// Changed by SetCheck
+					interval = i
+					ticker.Reset(interval)
+				}
+				if state := p.Status().State; state == StateStopped || state == StateAuthExpired || state == StateFailed {
+					// Disabled until started, resumed or restarted again
+					continue
+				}
+				if p.NodeCount() == 0 {
+					// Look for nodes more often while there are none
+					p.refreshNodes()
+					continue
 				}
+
+				success := p.Ping(ctx)
+				if success {
+					continue
+				}
+
+				p.mu.Lock()
+				p.failures++
+				failures := p.failures
+				p.mu.Unlock()
+				if failures >= failuresBeforeRefresh {
+					// The node may have been replaced
+					p.refreshNodes()
+				}
+
+				p.logger.Debug("Restarting proxy with different node", slog.String("name", p.Name))
+				err := p.restart()
+				if err != nil {
+					p.logger.Error("Failed to restart proxy", slog.String("name", p.Name), slog.String("error", err.Error()))
+				}
+			case <-refreshTicker.C:
+				p.refreshNodes()
 			case <-ctx.Done():
-				p.pingerMu.Unlock()
 				return
 			}
 		}
 	}()
 }
 
-func (p *Proxy) Stop() error {
-	if p.process == nil {
-		return nil // Nothing to stop
+// ExpireAuth closes the tunnel and moves the proxy to StateAuthExpired,
+// because no tunnel can be opened without a valid Teleport session. The
+// proxy is neither checked nor restarted until Resume is called.
+func (p *Proxy) ExpireAuth(reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == StateStopped || p.state == StateAuthExpired {
+		return nil
 	}
 
-	p.logger.Debug("Killing proxy process", slog.String("name", p.Name), slog.Int("pid", p.process.Pid))
+	err := p.stop()
+	p.setState(StateAuthExpired, reason)
+	return err
+}
 
-	err := p.process.Kill()
-	if err != nil {
-		return fmt.Errorf("failed to stop proxy for %s: %v", p.Name, err)
-	}
+// Resume opens the tunnel of a proxy in StateAuthExpired again, once there
+// is a valid Teleport session. Proxies in other states are left alone.
+func (p *Proxy) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	p.process = nil
-	p.healthy = false
+	if p.state != StateAuthExpired {
+		return nil
+	}
 
-	return nil
+	// Failures from before the session expired don't count
+	p.exits = 0
+	p.failures = 0
+	p.setState(StateStarting, "Teleport session renewed")
+	return p.start()
 }
 
-// Returns available Teleport nodes for a given selector.
-func getNodes(selector string) ([]string, error) {
-	cmd := exec.Command("tsh", "ls", "--format=names", selector) //nolint:gosec
+// SetCheck replaces the health check of a running proxy. A changed interval
+// applies from the next check on.
+func (p *Proxy) SetCheck(check HealthCheck) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	var stdout, stderr strings.Builder
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	p.Check = check.withDefaults()
+	p.pinger = newPinger(p.Port, p.Check.Timeout)
+}
 
-	err := cmd.Run()
+// Returns the time between health checks.
+func (p *Proxy) checkInterval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// Get exit code
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			// Non-exit error (e.g., command not found)
-			return nil, fmt.Errorf("failed to execute command: %v", err)
-		}
-	}
+	return p.Check.Interval
+}
 
-	stdoutStr := strings.TrimSpace(stdout.String())
-	stderrStr := strings.TrimSpace(stderr.String())
+// Stop closes the tunnel. The proxy will not be restarted until Start is
+// called again.
+func (p *Proxy) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// Log the results for debugging
-	if exitCode != 0 || stderrStr != "" {
-		return nil, fmt.Errorf("command failed with exit code %d, stderr: %s", exitCode, stderrStr)
+	err := p.stop()
+	if p.state != StateStopped {
+		close(p.stopCh)
 	}
+	p.setState(StateStopped, "")
+	return err
+}
 
-	if stdoutStr == "" {
-		return nil, fmt.Errorf("no nodes found for selector %s", selector)
+// Closes the tunnel. Must be called with p.mu held.
+func (p *Proxy) stop() error {
+	if p.tunnel == nil {
+		return nil // Nothing to stop
 	}
 
-	nodes := strings.Split(stdoutStr, "\n")
-	if len(nodes) == 0 || (len(nodes) == 1 && nodes[0] == "") {
-		return nil, fmt.Errorf("no nodes found for selector %s", selector)
+	p.logger.Debug("Closing proxy tunnel", slog.String("name", p.Name), slog.String("node", p.nodeActive))
+
+	err := p.tunnel.Close()
+	if err != nil {
+		return fmt.Errorf("failed to stop proxy for %s: %v", p.Name, err)
 	}
 
-	return nodes, nil
+	p.tunnel = nil
+	p.tunnelSince = time.Time{}
+
+	return nil
 }
 
-func newPinger(port int) (*http.Client, error) {
-	client := &http.Client{
-		Timeout: pingTimeout,
+func newPinger(port int, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		// Create a transport that uses the tunnel
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialTunnel(ctx, port, network, addr)
+			},
+		},
 	}
+}
 
-	// Create a dialer that uses the SOCKS5 proxy
+// Connects to addr through the SOCKS5 tunnel listening on the given port.
+func dialTunnel(ctx context.Context, port int, network, addr string) (net.Conn, error) {
 	dialer, err := proxy.SOCKS5("tcp", fmt.Sprintf("%s:%d", proxyHost, port), nil, proxy.Direct)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a transport that uses the proxy dialer
-	client.Transport = &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
-		},
-	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
 
-	return client, nil
+// Dial connects to addr through the tunnel of the proxy.
+func (p *Proxy) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialTunnel(ctx, p.Port, network, addr)
 }
 
-// Ping performs a GET request to the root URL of the provided host.
-// It returns information about the success, response code, any errors, and the duration.
+// Ping performs the health check request through the tunnel.
+// It returns whether the response passed the check, and records the response
+// code, any errors, and the duration.
 func (p *Proxy) Ping(ctx context.Context) bool {
 	result := &pingResult{}
-	if len(p.nodes) == 0 {
+	if p.NodeCount() == 0 {
 		return false
 	}
 
-	// Ensure the URL has a scheme
-	url := p.CheckEndpoint
-	if !hasScheme(url) {
-		url = "https://" + p.CheckEndpoint
-	}
+	p.mu.Lock()
+	check, pinger := p.Check, p.pinger
+	p.mu.Unlock()
 
 	// Create the request
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, check.Method, check.URL, nil)
 	if err != nil {
 		p.logger.Error("Failed to create ping request", slog.String("name", p.Name), slog.String("domain", p.Domain), slog.String("error", err.Error()))
 		result.err = fmt.Errorf("failed to create request: %w", err)
+		return false
 	}
 
 	// Execute the request with timing
 	startTime := time.Now()
-	resp, err := p.pinger.Do(req)
-	result.duration = time.Since(startTime)
+	resp, err := pinger.Do(req)
 	if err != nil {
 		result.err = fmt.Errorf("request failed: %w", err)
 	}
 
 	if resp != nil {
-		if resp.Body != nil {
-			_ = resp.Body.Close()
-		}
 		result.statusCode = resp.StatusCode
-		result.success = resp.StatusCode >= 200 && resp.StatusCode < 500
-	} else {
-		result.success = false
+		result.err = check.evaluate(resp)
+		result.success = result.err == nil
+		_ = resp.Body.Close()
+	}
+	result.duration = time.Since(startTime)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastPingResult = result
+	p.publish(PingResultEvent{
+		EventInfo:  p.eventInfo(),
+		Success:    result.success,
+		StatusCode: result.statusCode,
+		Duration:   result.duration,
+		Err:        result.err,
+	})
+
+	// Results of pings that were in flight while the tunnel was stopped or
+	// replaced don't say anything about the current tunnel.
+	if p.state != StateConnecting && p.state != StateHealthy && p.state != StateDegraded {
+		return result.success
 	}
 
 	if result.success {
-		if !p.healthy || p.lastPingResult == nil {
-			p.logger.Info("Proxy changed to healthy", slog.String("name", p.Name), slog.String("domain", p.Domain))
-		}
-		p.healthy = true
+		// The tunnel works, so earlier exits no longer count towards backoff
+		p.exits = 0
+		p.failures = 0
+		p.setState(StateHealthy, "")
 		p.logger.Debug("Ping succeeded", slog.String("name", p.Name), slog.Duration("duration", result.duration))
 	} else {
-		if p.healthy || p.lastPingResult == nil {
-			p.logger.Warn("Proxy changed to unhealthy", slog.String("name", p.Name), slog.String("domain", p.Domain))
-		}
-		p.healthy = false
+		p.setState(StateDegraded, fmt.Sprintf("health check failed: %v", result.err))
 		p.logger.Debug("Ping failed", slog.String("name", p.Name), slog.String("domain", p.Domain), slog.String("node", p.nodeActive), slog.Int("status_code", result.statusCode), slog.Duration("duration", result.duration), slog.String("error", fmt.Sprintf("%v", result.err)))
 	}
 
-	p.lastPingResult = result
-
 	return result.success
 }
 
-// hasScheme checks if the URL has a scheme (http:// or https://)
-func hasScheme(url string) bool {
-	return len(url) > 7 && (url[:7] == "http://" || url[:8] == "https://")
-}
-
 // ProxyStatus represents the current status of a proxy for display purposes.
 type ProxyStatus struct {
-	Name       string
-	Domain     string
-	Port       int
+	Name   string
+	Domain string
+	Hosts  Hosts
+	Port   int
+	// Current lifecycle state
+	State State
+	// Why the proxy is in its current state, may be empty
+	StateReason string
+	// When the proxy entered its current state
+	StateSince time.Time
+	// Whether State is StateHealthy
 	Healthy    bool
 	ActiveNode string
 	NodeCount  int
+	// Names of all nodes available
+	Nodes []string
+	// Number of restarts after the tunnel exited unexpectedly
+	Restarts int
+	// When the current tunnel was opened, zero if there is none
+	TunnelSince time.Time
+	// Error the last tunnel exited with, if any
+	LastExitErr error
 }
 
 // Status returns the current status of the proxy.
 func (p *Proxy) Status() ProxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	return ProxyStatus{
-		Name:       p.Name,
-		Domain:     p.Domain,
-		Port:       p.Port,
-		Healthy:    p.healthy,
-		ActiveNode: p.nodeActive,
-		NodeCount:  len(p.nodes),
+		Name:        p.Name,
+		Domain:      p.Domain,
+		Hosts:       p.Hosts,
+		Port:        p.Port,
+		State:       p.state,
+		StateReason: p.stateReason,
+		StateSince:  p.stateSince,
+		Healthy:     p.state == StateHealthy,
+		ActiveNode:  p.nodeActive,
+		NodeCount:   len(p.nodes),
+		Nodes:       slices.Clone(p.nodes),
+		Restarts:    p.restarts,
+		TunnelSince: p.tunnelSince,
+		LastExitErr: p.lastExitErr,
 	}
 }
 
 // IsHealthy returns whether the proxy is currently healthy.
 func (p *Proxy) IsHealthy() bool {
-	return p.healthy
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state == StateHealthy
+}
+
+// NodeCount returns the number of nodes available to the proxy.
+func (p *Proxy) NodeCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.nodes)
 }
@@ -0,0 +1,516 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+)
+
+// fakeBackend is a TunnelBackend that records the tunnels it opens.
+type fakeBackend struct {
+	nodes    []string
+	nodesErr error
+	openErr  error
+
+	mu     sync.Mutex
+	opened []*fakeTunnel
+}
+
+func (b *fakeBackend) Nodes() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.nodes), b.nodesErr
+}
+
+func (b *fakeBackend) setNodes(nodes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nodes = nodes
+}
+
+func (b *fakeBackend) Open(node string, port int) (Tunnel, error) {
+	if b.openErr != nil {
+		return nil, b.openErr
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := &fakeTunnel{node: node, port: port, done: make(chan struct{})}
+	b.opened = append(b.opened, t)
+	return t, nil
+}
+
+// fakeTunnel is a Tunnel that runs until closed or until exit is called.
+type fakeTunnel struct {
+	node string
+	port int
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (t *fakeTunnel) Wait() error {
+	<-t.done
+	return t.err
+}
+
+func (t *fakeTunnel) Close() error {
+	t.exit(nil)
+	return nil
+}
+
+// exit simulates the tunnel terminating with the given error.
+func (t *fakeTunnel) exit(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+func (t *fakeTunnel) closed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+var testCheck = HealthCheck{URL: "https://example.com/healthz"}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		backend    *fakeBackend
+		wantOpened int
+		wantNodes  int
+	}{
+		{
+			name:       "opens tunnel to one of the nodes",
+			backend:    &fakeBackend{nodes: []string{"node-a", "node-b"}},
+			wantOpened: 1,
+			wantNodes:  2,
+		},
+		{
+			name:       "no nodes",
+			backend:    &fakeBackend{},
+			wantOpened: 0,
+			wantNodes:  0,
+		},
+		{
+			name:       "node discovery fails",
+			backend:    &fakeBackend{nodesErr: errors.New("access denied")},
+			wantOpened: 0,
+			wantNodes:  0,
+		},
+		{
+			name:       "opening tunnel fails",
+			backend:    &fakeBackend{nodes: []string{"node-a"}, openErr: errors.New("boom")},
+			wantOpened: 0,
+			wantNodes:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(testLogger(), "test", "example.com", testCheck, tt.backend)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if got := len(tt.backend.opened); got != tt.wantOpened {
+				t.Errorf("tunnels opened = %d, want %d", got, tt.wantOpened)
+			}
+
+			status := p.Status()
+			if status.NodeCount != tt.wantNodes {
+				t.Errorf("NodeCount = %d, want %d", status.NodeCount, tt.wantNodes)
+			}
+			if tt.wantOpened > 0 {
+				tunnel := tt.backend.opened[0]
+				if status.ActiveNode != tunnel.node {
+					t.Errorf("ActiveNode = %q, want %q", status.ActiveNode, tunnel.node)
+				}
+				if tunnel.port != p.Port {
+					t.Errorf("tunnel port = %d, want %d", tunnel.port, p.Port)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_validation(t *testing.T) {
+	backend := &fakeBackend{}
+	if _, err := New(testLogger(), "", "example.com", testCheck, backend); err == nil {
+		t.Error("New() with empty name should fail")
+	}
+	if _, err := New(testLogger(), "test", "example.com", testCheck, nil); err == nil {
+		t.Error("New() with nil backend should fail")
+	}
+}
+
+func TestProxy_Stop(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !backend.opened[0].closed() {
+		t.Error("Stop() did not close the tunnel")
+	}
+
+	// Stopping twice is a no-op
+	err = p.Stop()
+	if err != nil {
+		t.Errorf("second Stop() error = %v", err)
+	}
+}
+
+func TestProxy_Start_running(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = p.Stop() }()
+
+	// Starting a running proxy keeps its tunnel
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if len(backend.opened) != 1 || backend.opened[0].closed() {
+		t.Errorf("opened %d tunnels, want the first one still open", len(backend.opened))
+	}
+
+	// Stopping closes the only tunnel
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !backend.opened[0].closed() {
+		t.Error("Stop() did not close the tunnel")
+	}
+}
+
+func TestProxy_selectNode(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b", "node-c"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for range 10 {
+		previous := p.nodeActive
+		if got := p.selectNode(); got == previous {
+			t.Fatalf("selectNode() = %q, want a node other than %q", got, previous)
+		}
+	}
+}
+
+func TestProxy_Restart(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	err = p.Restart()
+	if err != nil {
+		t.Fatalf("Restart() error = %v", err)
+	}
+	tunnels := backend.tunnels()
+	if len(tunnels) != 2 || !tunnels[0].closed() {
+		t.Fatalf("Restart() should close the tunnel and open a new one, got %d tunnels", len(tunnels))
+	}
+	if tunnels[1].node != tunnels[0].node {
+		t.Errorf("restarted tunnel uses node %q, want %q", tunnels[1].node, tunnels[0].node)
+	}
+	if got := p.Status().State; got != StateConnecting {
+		t.Errorf("State = %v, want %v", got, StateConnecting)
+	}
+
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := p.Restart(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Restart() of stopped proxy error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestProxy_SwitchNode(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	first := p.Status().ActiveNode
+
+	err = p.SwitchNode("")
+	if err != nil {
+		t.Fatalf("SwitchNode() error = %v", err)
+	}
+	tunnels := backend.tunnels()
+	if len(tunnels) != 2 || tunnels[1].node == first {
+		t.Fatalf("SwitchNode() should open a tunnel to a node other than %q", first)
+	}
+
+	err = p.SwitchNode(first)
+	if err != nil {
+		t.Fatalf("SwitchNode(%q) error = %v", first, err)
+	}
+	if got := p.Status().ActiveNode; got != first {
+		t.Errorf("ActiveNode = %q, want %q", got, first)
+	}
+
+	if err := p.SwitchNode("node-c"); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("SwitchNode() to unknown node error = %v, want %v", err, ErrUnknownNode)
+	}
+}
+
+func TestProxy_ExpireAuth(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	err = p.ExpireAuth("session expired")
+	if err != nil {
+		t.Fatalf("ExpireAuth() error = %v", err)
+	}
+	if !backend.tunnels()[0].closed() {
+		t.Error("ExpireAuth() did not close the tunnel")
+	}
+	if got := p.Status().State; got != StateAuthExpired {
+		t.Errorf("State = %v, want %v", got, StateAuthExpired)
+	}
+
+	// Health check failures must not restart it
+	err = p.restart()
+	if err != nil || len(backend.tunnels()) != 1 {
+		t.Errorf("restart() of proxy with expired session opened a tunnel, error = %v", err)
+	}
+
+	err = p.Resume()
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if got := len(backend.tunnels()); got != 2 {
+		t.Errorf("tunnels opened = %d, want 2", got)
+	}
+	if got := p.Status().State; got != StateConnecting {
+		t.Errorf("State after Resume() = %v, want %v", got, StateConnecting)
+	}
+
+	// Resuming a working proxy has no effect
+	err = p.Resume()
+	if err != nil || len(backend.tunnels()) != 2 {
+		t.Errorf("Resume() of working proxy opened a tunnel, error = %v", err)
+	}
+}
+
+// Returns the tunnels opened so far.
+func (b *fakeBackend) tunnels() []*fakeTunnel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*fakeTunnel(nil), b.opened...)
+}
+
+// Waits until the backend has opened n tunnels.
+func waitForTunnels(t *testing.T, b *fakeBackend, n int) []*fakeTunnel {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if tunnels := b.tunnels(); len(tunnels) >= n {
+			return tunnels
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d tunnels, got %d", n, len(b.tunnels()))
+	return nil
+}
+
+func TestProxy_supervise(t *testing.T) {
+	restartBackoffMin = time.Millisecond
+	defer func() { restartBackoffMin = time.Second }()
+
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	p.mu.Lock()
+	p.setState(StateHealthy, "")
+	p.mu.Unlock()
+
+	exitErr := errors.New("certificate expired")
+	tunnels := waitForTunnels(t, backend, 1)
+	opened := p.Status().TunnelSince
+	if opened.IsZero() {
+		t.Error("TunnelSince is zero while the tunnel is open")
+	}
+	tunnels[0].exit(exitErr)
+
+	tunnels = waitForTunnels(t, backend, 2)
+	if tunnels[1].node == tunnels[0].node {
+		t.Errorf("restarted tunnel uses node %q again, want a different node", tunnels[1].node)
+	}
+
+	status := p.Status()
+	if status.Healthy {
+		t.Error("proxy still healthy after tunnel exit")
+	}
+	if status.State != StateConnecting {
+		t.Errorf("State = %v, want %v", status.State, StateConnecting)
+	}
+	if status.Restarts != 1 {
+		t.Errorf("Restarts = %d, want 1", status.Restarts)
+	}
+	if !errors.Is(status.LastExitErr, exitErr) {
+		t.Errorf("LastExitErr = %v, want %v", status.LastExitErr, exitErr)
+	}
+	if !status.TunnelSince.After(opened) {
+		t.Errorf("TunnelSince = %v, want after %v", status.TunnelSince, opened)
+	}
+
+	// A tunnel closed by Stop must not be restarted
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := p.Status().State; got != StateStopped {
+		t.Errorf("State after Stop() = %v, want %v", got, StateStopped)
+	}
+	if got := p.Status().TunnelSince; !got.IsZero() {
+		t.Errorf("TunnelSince after Stop() = %v, want zero", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := len(backend.tunnels()); got != 2 {
+		t.Errorf("tunnels opened after Stop = %d, want 2", got)
+	}
+}
+
+func TestProxy_supervise_classified(t *testing.T) {
+	restartBackoffMin = time.Millisecond
+	defer func() { restartBackoffMin = time.Second }()
+
+	tests := []struct {
+		name        string
+		err         error
+		wantState   State
+		wantTunnels int
+	}{
+		{name: "expired", err: &tsherr.Error{Kind: tsherr.ErrExpired, ExitCode: 1}, wantState: StateAuthExpired, wantTunnels: 1},
+		{name: "access denied", err: &tsherr.Error{Kind: tsherr.ErrAccessDenied, ExitCode: 1}, wantState: StateFailed, wantTunnels: 1},
+		{name: "node not found", err: &tsherr.Error{Kind: tsherr.ErrNodeNotFound, ExitCode: 1}, wantState: StateConnecting, wantTunnels: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+			p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() { _ = p.Stop() }()
+
+			tunnels := waitForTunnels(t, backend, 1)
+			tunnels[0].exit(tt.err)
+			tunnels = waitForTunnels(t, backend, tt.wantTunnels)
+			time.Sleep(20 * time.Millisecond)
+
+			if got := len(backend.tunnels()); got != tt.wantTunnels {
+				t.Errorf("tunnels opened = %d, want %d", got, tt.wantTunnels)
+			}
+			status := p.Status()
+			if status.State != tt.wantState {
+				t.Errorf("State = %v, want %v", status.State, tt.wantState)
+			}
+			if errors.Is(tt.err, tsherr.ErrNodeNotFound) && status.NodeCount != 1 {
+				t.Errorf("NodeCount = %d, want the missing node dropped", status.NodeCount)
+			}
+		})
+	}
+}
+
+func TestProxy_PingConstantly_cancel(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.PingConstantly(ctx)
+	// A second call while the loop is running has no effect
+	p.PingConstantly(ctx)
+	cancel()
+
+	waitForState(t, p, StateStopped)
+	if !backend.tunnels()[0].closed() {
+		t.Error("cancelling the context did not close the tunnel")
+	}
+}
+
+func TestProxy_Stop_pendingRestart(t *testing.T) {
+	backend := &fakeBackend{nodes: []string{"node-a"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The restart is scheduled at least half a second out
+	waitForTunnels(t, backend, 1)[0].exit(errors.New("connection reset"))
+	waitForState(t, p, StateRestarting)
+
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	time.Sleep(restartBackoffMin + 100*time.Millisecond)
+	if got := p.Status().State; got != StateStopped {
+		t.Errorf("State = %v, want %v", got, StateStopped)
+	}
+	if got := len(backend.tunnels()); got != 1 {
+		t.Errorf("tunnels opened = %d, want 1", got)
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{n: 1, want: restartBackoffMin},
+		{n: 2, want: 2 * restartBackoffMin},
+		{n: 3, want: 4 * restartBackoffMin},
+		{n: 100, want: restartBackoffMax},
+	}
+	for _, tt := range tests {
+		for range 10 {
+			got := backoff(tt.n)
+			if got < tt.want/2 || got > tt.want {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.n, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+)
+
+// State is the lifecycle state of a proxy.
+type State int
+
+const (
+	// StateStarting is the state of a proxy that was just created.
+	StateStarting State = iota
+	// StateConnecting means the tunnel was opened, but no health check has
+	// succeeded yet.
+	StateConnecting
+	// StateHealthy means the last health check succeeded.
+	StateHealthy
+	// StateDegraded means the last health check failed, or the tunnel could
+	// not be opened.
+	StateDegraded
+	// StateRestarting means the tunnel exited or is being replaced, and a
+	// new one will be opened.
+	StateRestarting
+	// StateNoNodes means there are no nodes to open a tunnel to.
+	StateNoNodes
+	// StateStopped means the proxy was stopped on purpose.
+	StateStopped
+	// StateAuthExpired means the Teleport session expired, so no tunnel can
+	// be opened until the user logs in again.
+	StateAuthExpired
+	// StateFailed means the tunnel failed in a way retrying won't fix, like
+	// a missing tsh or denied access. It is only opened again on request.
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "Starting"
+	case StateConnecting:
+		return "Connecting"
+	case StateHealthy:
+		return "Healthy"
+	case StateDegraded:
+		return "Degraded"
+	case StateRestarting:
+		return "Restarting"
+	case StateNoNodes:
+		return "NoNodes"
+	case StateStopped:
+		return "Stopped"
+	case StateAuthExpired:
+		return "AuthExpired"
+	case StateFailed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Moves the proxy to the given state. The reason is kept for display, and
+// the transition is logged if the state changed. Must be called with p.mu
+// held.
+func (p *Proxy) setState(state State, reason string) {
+	previous := p.state
+	p.stateReason = reason
+	if state == previous {
+		return
+	}
+
+	p.state = state
+	p.stateSince = time.Now()
+	p.publish(StateChangedEvent{EventInfo: p.eventInfo(), Previous: previous, State: state, Reason: reason})
+
+	attrs := []any{slog.String("name", p.Name), slog.String("from", previous.String()), slog.String("to", state.String())}
+	if reason != "" {
+		attrs = append(attrs, slog.String("reason", reason))
+	}
+
+	switch state {
+	case StateHealthy, StateStopped:
+		p.logger.Info("Proxy state changed", attrs...)
+	case StateDegraded, StateNoNodes, StateAuthExpired:
+		p.logger.Warn("Proxy state changed", attrs...)
+	case StateFailed:
+		p.logger.Error("Proxy state changed", attrs...)
+	default:
+		p.logger.Debug("Proxy state changed", attrs...)
+	}
+}
+
+// Moves the proxy to StateAuthExpired or StateFailed if err of the tunnel
+// means that opening it again is pointless, with the remediation as reason.
+// Returns whether it did. Must be called with p.mu held.
+func (p *Proxy) failFor(err error) bool {
+	var state State
+	switch {
+	case tsherr.NeedsLogin(err):
+		state = StateAuthExpired
+	case tsherr.Permanent(err):
+		state = StateFailed
+	default:
+		return false
+	}
+
+	p.setState(state, fmt.Sprintf("%v, %s", err, tsherr.Remediation(err)))
+	return true
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxy_states(t *testing.T) {
+	restartBackoffMin = time.Hour // keep exited tunnels in StateRestarting
+	defer func() { restartBackoffMin = time.Second }()
+
+	backend := &fakeBackend{}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	assertState := func(want State) {
+		t.Helper()
+		status := p.Status()
+		if status.State != want {
+			t.Fatalf("State = %v (%s), want %v", status.State, status.StateReason, want)
+		}
+		if status.StateSince.IsZero() {
+			t.Error("StateSince is not set")
+		}
+	}
+
+	assertState(StateNoNodes)
+
+	backend.setNodes("node-a", "node-b")
+	p.refreshNodes()
+	assertState(StateConnecting)
+
+	// Nothing listens on the tunnel port, so the check fails
+	p.Ping(context.Background())
+	assertState(StateDegraded)
+	if reason := p.Status().StateReason; reason == "" {
+		t.Error("StateReason is empty for failed check")
+	}
+
+	since := p.Status().StateSince
+	p.Ping(context.Background())
+	if got := p.Status().StateSince; !got.Equal(since) {
+		t.Error("StateSince changed without a state change")
+	}
+
+	backend.tunnels()[0].exit(errors.New("connection reset"))
+	waitForState(t, p, StateRestarting)
+
+	// Late ping results must not override the restart
+	p.Ping(context.Background())
+	assertState(StateRestarting)
+
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	assertState(StateStopped)
+	if p.IsHealthy() {
+		t.Error("IsHealthy() = true for stopped proxy")
+	}
+
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	assertState(StateConnecting)
+}
+
+// Exercises concurrent access to the proxy state, meant to be run with -race.
+func TestProxy_concurrentAccess(t *testing.T) {
+	restartBackoffMin = time.Millisecond
+	defer func() { restartBackoffMin = time.Second }()
+
+	backend := &fakeBackend{nodes: []string{"node-a", "node-b"}}
+	p, err := New(testLogger(), "test", "example.com", testCheck, backend)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				switch i {
+				case 0:
+					_ = p.Status()
+					_ = p.IsHealthy()
+				case 1:
+					p.refreshNodes()
+				case 2:
+					_ = p.restart()
+				case 3:
+					for _, tunnel := range backend.tunnels() {
+						tunnel.exit(errors.New("killed"))
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+func waitForState(t *testing.T, p *Proxy, want State) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if p.Status().State == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for state %v, got %v", want, p.Status().State)
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	rand "math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+)
+
+var (
+	// Delay before the first restart after a tunnel exited unexpectedly.
+	restartBackoffMin = 1 * time.Second
+	// Upper bound for the delay between restarts.
+	restartBackoffMax = 60 * time.Second
+)
+
+// Waits for the tunnel to exit. If it exits without having been closed by
+// Stop or restart, the proxy moves to StateRestarting right away and a new
+// tunnel is opened after a backoff delay. Closing stopCh aborts the restart.
+func (p *Proxy) supervise(tunnel Tunnel, stopCh <-chan struct{}) {
+	err := tunnel.Wait()
+
+	p.mu.Lock()
+	if p.tunnel != tunnel {
+		// Closed on purpose, or already replaced by another tunnel.
+		p.mu.Unlock()
+		return
+	}
+
+	p.tunnel = nil
+	p.tunnelSince = time.Time{}
+	p.lastExitErr = err
+	node := p.nodeActive
+	p.publish(ProcessExitedEvent{EventInfo: p.eventInfo(), Node: node, Err: err})
+	if p.failFor(err) {
+		// Restarting won't help
+		p.mu.Unlock()
+		return
+	}
+	if errors.Is(err, tsherr.ErrNodeNotFound) {
+		// Not tried again until the node list is refreshed
+		p.dropNode(node)
+	}
+
+	p.exits++
+	delay := backoff(p.exits)
+	p.setState(StateRestarting, fmt.Sprintf("tunnel exited: %v", err))
+	p.publish(RestartScheduledEvent{EventInfo: p.eventInfo(), Delay: delay, Attempt: p.exits})
+	p.mu.Unlock()
+
+	attrs := []any{slog.String("name", p.Name), slog.String("node", node), slog.Duration("restart_in", delay)}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	p.logger.Warn("Proxy tunnel exited unexpectedly", attrs...)
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return
+		}
+
+		p.mu.Lock()
+		if p.state != StateRestarting || p.tunnel != nil {
+			// Stopped, or restarted by someone else in the meantime
+			p.mu.Unlock()
+			return
+		}
+
+		p.selectNode()
+		err := p.start()
+		if err == nil {
+			p.restarts++
+			p.mu.Unlock()
+			return
+		}
+		if p.state == StateNoNodes || p.state == StateAuthExpired || p.state == StateFailed {
+			// Will be started once nodes show up again, after a new login
+			// or on request
+			p.mu.Unlock()
+			return
+		}
+
+		p.exits++
+		delay = backoff(p.exits)
+		p.setState(StateRestarting, fmt.Sprintf("restart failed: %v", err))
+		p.publish(RestartScheduledEvent{EventInfo: p.eventInfo(), Delay: delay, Attempt: p.exits})
+		p.mu.Unlock()
+
+		p.logger.Error("Failed to restart proxy", slog.String("name", p.Name), slog.Duration("retry_in", delay), slog.String("error", err.Error()))
+	}
+}
+
+// Removes a node the backend could not find from the node list, so that
+// the tunnel is moved to another one. Must be called with p.mu held.
+func (p *Proxy) dropNode(node string) {
+	if !slices.Contains(p.nodes, node) {
+		return
+	}
+
+	p.nodes = slices.DeleteFunc(slices.Clone(p.nodes), func(n string) bool { return n == node })
+	p.publish(NodesChangedEvent{EventInfo: p.eventInfo(), Removed: []string{node}, Nodes: slices.Clone(p.nodes)})
+	if p.nodeActive == node {
+		p.nodeActive = ""
+	}
+}
+
+// Returns the delay before restart attempt n (starting at 1), growing
+// exponentially up to restartBackoffMax. Half of the delay is randomized so
+// that proxies failing at the same time don't restart in lockstep.
+func backoff(n int) time.Duration {
+	delay := restartBackoffMin
+	for i := 1; i < n && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, restartBackoffMax)
+
+	half := delay / 2
+	return half + rand.N(half+1) //nolint:gosec
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+)
+
+// TshBackend opens tunnels using `tsh ssh --dynamic-forward`.
+type TshBackend struct {
+	// Teleport proxy to use, passed as `--proxy` if not empty. Without it,
+	// tsh uses the active profile.
+	Proxy string
+	// Teleport cluster the nodes are in, passed as `--cluster` if not empty
+	Cluster string
+	// Runs tsh, tshexec.Default if nil
+	Runner tshexec.Runner
+
+	// Label selector passed to `tsh ls` to find the nodes of the
+	// installation, like `ins=NAME,role=control-plane`.
+	selector string
+	// Optional predicate expression passed to `tsh ls --query`.
+	query string
+	// SSH login used for the tunnel.
+	login string
+}
+
+// NewTshBackend returns a TshBackend for the nodes matching the label
+// selector and, if not empty, the query predicate. Tunnels are opened as
+// the given SSH login.
+func NewTshBackend(selector string, query string, login string) (*TshBackend, error) {
+	if selector == "" {
+		return nil, fmt.Errorf("selector must not be empty")
+	}
+	if login == "" {
+		return nil, fmt.Errorf("login must not be empty")
+	}
+
+	return &TshBackend{
+		selector: selector,
+		query:    query,
+		login:    login,
+	}, nil
+}
+
+// Nodes returns available Teleport nodes for the installation.
+func (b *TshBackend) Nodes() ([]string, error) {
+	args := append([]string{"ls"}, b.profileArgs()...)
+	args = append(args, "--format=names")
+	if b.query != "" {
+		args = append(args, "--query", b.query)
+	}
+	args = append(args, b.selector)
+	cmd := tshexec.Command(b.Runner, args...)
+
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, tsherr.Wrap(err, stderr.String())
+	}
+
+	stdoutStr := strings.TrimSpace(stdout.String())
+	stderrStr := strings.TrimSpace(stderr.String())
+
+	// tsh may exit with 0 even though it failed
+	if stderrStr != "" {
+		return nil, &tsherr.Error{Kind: tsherr.Classify(stderrStr), Stderr: stderrStr}
+	}
+
+	if stdoutStr == "" {
+		return nil, nil // No nodes found
+	}
+
+	return strings.Split(stdoutStr, "\n"), nil
+}
+
+// Returns the flags selecting the Teleport proxy and cluster.
+func (b *TshBackend) profileArgs() []string {
+	var args []string
+	if b.Proxy != "" {
+		args = append(args, "--proxy", b.Proxy)
+	}
+	if b.Cluster != "" {
+		args = append(args, "--cluster", b.Cluster)
+	}
+	return args
+}
+
+// Open starts a `tsh ssh` process forwarding the given port to the node.
+// The node is addressed by its name label in addition to the selector, as
+// node names are not necessarily unique across installations.
+func (b *TshBackend) Open(node string, port int) (Tunnel, error) {
+	host := fmt.Sprintf("%s@node=%s,%s", b.login, node, b.selector)
+	args := append([]string{"ssh"}, b.profileArgs()...)
+	args = append(args, "--no-remote-exec", "--dynamic-forward", fmt.Sprintf("%d", port), host)
+	cmd := tshexec.Command(b.Runner, args...)
+
+	t := &tshTunnel{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+	cmd.Stderr = &t.stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, tsherr.Wrap(err, "")
+	}
+
+	go t.wait()
+
+	return t, nil
+}
+
+// TunnelExitError is returned by Wait when a `tsh ssh` tunnel process exits.
+type TunnelExitError struct {
+	// Exit code of the process, -1 if it was killed by a signal.
+	ExitCode int
+	// What the process wrote to stderr.
+	Stderr string
+}
+
+// Unwrap returns the tsherr sentinel error for what the process wrote to
+// stderr, nil if the failure is not known.
+func (e *TunnelExitError) Unwrap() error {
+	return tsherr.Classify(e.Stderr)
+}
+
+func (e *TunnelExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("tsh exited with code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("tsh exited with code %d, stderr: %s", e.ExitCode, e.Stderr)
+}
+
+// tshTunnel is a running `tsh ssh` process.
+type tshTunnel struct {
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	done   chan struct{}
+	err    error
+}
+
+// Reaps the process once it exits.
+func (t *tshTunnel) wait() {
+	err := t.cmd.Wait()
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		t.err = &TunnelExitError{ExitCode: exitErr.ExitCode(), Stderr: strings.TrimSpace(t.stderr.String())}
+	case err != nil:
+		t.err = err
+	default:
+		t.err = &TunnelExitError{ExitCode: 0, Stderr: strings.TrimSpace(t.stderr.String())}
+	}
+
+	close(t.done)
+}
+
+func (t *tshTunnel) Wait() error {
+	<-t.done
+	return t.err
+}
+
+func (t *tshTunnel) Close() error {
+	select {
+	case <-t.done:
+		return nil // Already exited
+	default:
+	}
+
+	return t.cmd.Process.Kill()
+}
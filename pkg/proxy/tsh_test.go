@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshfake"
+)
+
+func TestMain(m *testing.M) {
+	tshfake.Main()
+	os.Exit(m.Run())
+}
+
+// Returns a port nothing listens on right now.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func testTshBackend(t *testing.T) (*TshBackend, *tshfake.Fake) {
+	t.Helper()
+	fake := tshfake.New(t)
+	fake.Login("teleport.example.com", time.Now().Add(time.Hour), "root")
+	fake.SetNodes("ins=one", "node-a", "node-b")
+
+	backend, err := NewTshBackend("ins=one", "", "root")
+	if err != nil {
+		t.Fatalf("NewTshBackend() error = %v", err)
+	}
+	backend.Proxy = "teleport.example.com"
+	backend.Cluster = "one"
+	backend.Runner = fake
+	return backend, fake
+}
+
+func TestTshBackend(t *testing.T) {
+	backend, fake := testTshBackend(t)
+
+	nodes, err := backend.Nodes()
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	if !slices.Equal(nodes, []string{"node-a", "node-b"}) {
+		t.Errorf("Nodes() = %v, want node-a and node-b", nodes)
+	}
+	want := []string{"ls", "--proxy", "teleport.example.com", "--cluster", "one", "--format=names", "ins=one"}
+	if calls := fake.Called("ls"); len(calls) != 1 || !slices.Equal(calls[0], want) {
+		t.Errorf("tsh was run with %q, want %q", calls, want)
+	}
+
+	// The tunnel is a SOCKS5 proxy
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	fake.Route("happaapi.one.example:80", server.Listener.Addr().String())
+
+	port := freePort(t)
+	tunnel, err := backend.Open("node-a", port)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = tunnel.Close() }()
+
+	pinger := newPinger(port, time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := pinger.Get("http://happaapi.one.example/healthz")
+		if err == nil {
+			_ = resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request through tunnel failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The tunnel exits once the node goes down
+	fake.SetDown("node-a", true)
+	err = tunnel.Wait()
+	if !errors.Is(err, tsherr.ErrNetworkUnreachable) {
+		t.Errorf("Wait() error = %v, want %v", err, tsherr.ErrNetworkUnreachable)
+	}
+}
+
+func TestTshBackend_failures(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*tshfake.Fake)
+		node  string
+		want  error
+	}{
+		{name: "logged out", setup: func(f *tshfake.Fake) { f.Logout("teleport.example.com") }, node: "node-a", want: tsherr.ErrNotLoggedIn},
+		{name: "expired", setup: func(f *tshfake.Fake) { f.Expire("teleport.example.com") }, node: "node-a", want: tsherr.ErrExpired},
+		{name: "login not allowed", setup: func(f *tshfake.Fake) { f.Login("teleport.example.com", time.Now().Add(time.Hour), "admin") }, node: "node-a", want: tsherr.ErrAccessDenied},
+		{name: "unknown node", setup: func(f *tshfake.Fake) {}, node: "node-c", want: tsherr.ErrNodeNotFound},
+		{name: "node down", setup: func(f *tshfake.Fake) { f.SetDown("node-a", true) }, node: "node-a", want: tsherr.ErrNetworkUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, fake := testTshBackend(t)
+			tt.setup(fake)
+
+			tunnel, err := backend.Open(tt.node, freePort(t))
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- tunnel.Wait() }()
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				_ = tunnel.Close()
+				t.Fatal("tunnel did not exit")
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Wait() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Package tsherr classifies failures of tsh commands by what tsh printed to
+// stderr, so callers can tell the user how to fix them and skip retries
+// that cannot succeed.
+package tsherr
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	// ErrNotLoggedIn means the user is not logged in to Teleport.
+	ErrNotLoggedIn = errors.New("not logged in to Teleport")
+	// ErrExpired means the Teleport session or certificate has expired.
+	ErrExpired = errors.New("session expired")
+	// ErrInvalidKeyPair means the keys stored by tsh are broken.
+	ErrInvalidKeyPair = errors.New("private and public keys do not form a valid keypair")
+	// ErrAccessDenied means the Teleport roles of the user don't allow the
+	// requested access.
+	ErrAccessDenied = errors.New("access denied by Teleport")
+	// ErrNodeNotFound means no node matched the requested host.
+	ErrNodeNotFound = errors.New("node not found in Teleport")
+	// ErrNetworkUnreachable means the Teleport proxy or node could not be
+	// reached.
+	ErrNetworkUnreachable = errors.New("cannot reach Teleport")
+	// ErrTshMissing means tsh is not installed or not in PATH.
+	ErrTshMissing = errors.New("tsh not found")
+	// ErrVersionTooOld means the Teleport cluster doesn't support the
+	// version of tsh.
+	ErrVersionTooOld = errors.New("tsh version not supported by Teleport")
+)
+
+// Phrases in stderr of tsh indicating each kind of failure, in the order
+// they are checked. All lowercase.
+var patterns = []struct {
+	err     error
+	phrases []string
+}{
+	{ErrInvalidKeyPair, []string{"do not form a valid keypair"}},
+	{ErrNotLoggedIn, []string{"not logged in"}},
+	// tsh fails to authenticate with an expired or invalid client
+	// certificate, which a new login fixes
+	{ErrExpired, []string{"profile expired", "has expired", "session expired", "certificate expired", "unable to authenticate"}},
+	{ErrVersionTooOld, []string{"minimum client version", "not supported by the server", "please upgrade tsh", "client version is too old"}},
+	{ErrAccessDenied, []string{"access denied", "not authorized"}},
+	{ErrNodeNotFound, []string{"node not found", "no matching node", "no nodes match", "no hosts match", "not found in cluster"}},
+	{ErrNetworkUnreachable, []string{"network is unreachable", "no route to host", "connection refused", "no such host", "i/o timeout", "connection reset"}},
+}
+
+// Classify returns the sentinel error for the failure stderr of tsh
+// describes, or nil if it is not known.
+func Classify(stderr string) error {
+	stderr = strings.ToLower(stderr)
+	for _, p := range patterns {
+		for _, phrase := range p.phrases {
+			if strings.Contains(stderr, phrase) {
+				return p.err
+			}
+		}
+	}
+	return nil
+}
+
+// Error is a failed tsh command.
+type Error struct {
+	// Sentinel error the failure was classified as, nil if unknown
+	Kind error
+	// Exit code of tsh, -1 if it did not run to completion
+	ExitCode int
+	// What tsh wrote to stderr
+	Stderr string
+	// Error returned when running the command
+	Err error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Kind != nil && e.Stderr != "":
+		return fmt.Sprintf("%s: %s", e.Kind, e.Stderr)
+	case e.Kind != nil:
+		return e.Kind.Error()
+	case e.ExitCode > 0 || (e.ExitCode == 0 && e.Err == nil):
+		// tsh may exit with 0 even though it failed
+		return fmt.Sprintf("tsh exited with code %d, stderr: %s", e.ExitCode, e.Stderr)
+	case e.ExitCode == 0:
+		return fmt.Sprintf("%v, stderr: %s", e.Err, e.Stderr)
+	default:
+		return fmt.Sprintf("failed to execute tsh: %v", e.Err)
+	}
+}
+
+// Unwrap returns the sentinel error, if known, and the error of running the
+// command.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Kind, e.Err} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Wrap turns err of running a tsh command that wrote stderr into an *Error
+// with the failure classified. It returns nil if err is nil.
+func Wrap(err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+
+	stderr = strings.TrimSpace(stderr)
+	e := &Error{Kind: Classify(stderr), ExitCode: -1, Stderr: stderr, Err: err}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		e.ExitCode = exitErr.ExitCode()
+	case errors.Is(err, exec.ErrNotFound):
+		e.Kind = ErrTshMissing
+	}
+	return e
+}
+
+// NeedsLogin returns whether err means that the user has to log in to
+// Teleport again.
+func NeedsLogin(err error) bool {
+	return errors.Is(err, ErrNotLoggedIn) || errors.Is(err, ErrExpired) || errors.Is(err, ErrInvalidKeyPair)
+}
+
+// Permanent returns whether err won't go away by retrying, as it needs to
+// be fixed outside of linkmeup first. Failures that a new login fixes are
+// not permanent, see NeedsLogin.
+func Permanent(err error) bool {
+	return errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrTshMissing) || errors.Is(err, ErrVersionTooOld)
+}
+
+// Remediation returns what the user can do about err, or an empty string
+// if it is not a known failure.
+func Remediation(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidKeyPair):
+		return "log out with 'tsh logout' and log in again with 'tsh login'"
+	case errors.Is(err, ErrNotLoggedIn):
+		return "log in with 'tsh login'"
+	case errors.Is(err, ErrExpired):
+		return "log in again with 'tsh login'"
+	case errors.Is(err, ErrAccessDenied):
+		return "check that your Teleport roles allow the SSH login on the nodes of the installation"
+	case errors.Is(err, ErrNodeNotFound):
+		return "check the selector and query of the installation with 'tsh ls'"
+	case errors.Is(err, ErrNetworkUnreachable):
+		return "check your network connection and the Teleport proxy address"
+	case errors.Is(err, ErrTshMissing):
+		return "install tsh and make sure it is in your PATH, see https://goteleport.com/docs/connect-your-client/tsh/"
+	case errors.Is(err, ErrVersionTooOld):
+		return "update tsh to the version of your Teleport cluster"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,117 @@
+package tsherr
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error
+	}{
+		{stderr: "ERROR: Not logged in.", want: ErrNotLoggedIn},
+		{stderr: "ERROR: Active profile expired.", want: ErrExpired},
+		{stderr: "ERROR: ssh: cert has expired", want: ErrExpired},
+		{stderr: "ERROR: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain", want: ErrExpired},
+		{stderr: "ERROR: private and public keys do not form a valid keypair", want: ErrInvalidKeyPair},
+		{stderr: "ERROR: access denied to root connecting to node-a", want: ErrAccessDenied},
+		{stderr: "ERROR: no hosts match the labels", want: ErrNodeNotFound},
+		{stderr: "ERROR: dial tcp: lookup teleport.example.com: no such host", want: ErrNetworkUnreachable},
+		{stderr: "ERROR: client version 12.0.0 is not supported by the server, minimum client version is 14.0.0", want: ErrVersionTooOld},
+		{stderr: "ERROR: something else", want: nil},
+		{stderr: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stderr, func(t *testing.T) {
+			if got := Classify(tt.stderr); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrap_unableToAuthenticate(t *testing.T) {
+	// What tsh ssh prints when its certificate is no longer accepted
+	stderr := "ERROR: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain\n"
+	err := Wrap(exec.Command("sh", "-c", "exit 1").Run(), stderr)
+	if !NeedsLogin(err) || Permanent(err) {
+		t.Errorf("Wrap() = %v, want it to need a login, not to be permanent", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if Wrap(nil, "ERROR: Not logged in.") != nil {
+		t.Error("Wrap(nil) is not nil")
+	}
+
+	err := Wrap(exec.Command("sh", "-c", "exit 3").Run(), " ERROR: Not logged in.\n")
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Wrap() = %T, want *Error", err)
+	}
+	if e.ExitCode != 3 || e.Stderr != "ERROR: Not logged in." {
+		t.Errorf("ExitCode, Stderr = %d, %q", e.ExitCode, e.Stderr)
+	}
+	if !errors.Is(err, ErrNotLoggedIn) || !NeedsLogin(err) || Permanent(err) {
+		t.Errorf("Wrap() = %v, want it classified as not logged in", err)
+	}
+
+	err = Wrap(exec.Command("tsh-does-not-exist").Run(), "")
+	if !errors.Is(err, ErrTshMissing) || !errors.Is(err, exec.ErrNotFound) || !Permanent(err) {
+		t.Errorf("Wrap() = %v, want it classified as tsh missing", err)
+	}
+	if Remediation(err) == "" {
+		t.Error("Remediation() is empty for missing tsh")
+	}
+
+	err = Wrap(exec.Command("sh", "-c", "exit 1").Run(), "ERROR: something else")
+	if err.Error() != "tsh exited with code 1, stderr: ERROR: something else" {
+		t.Errorf("Error() = %q", err)
+	}
+	if NeedsLogin(err) || Permanent(err) || Remediation(err) != "" {
+		t.Errorf("unknown failure %v was classified", err)
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{
+			name: "classified",
+			err:  &Error{Kind: ErrNotLoggedIn, ExitCode: 1, Stderr: "ERROR: Not logged in."},
+			want: "not logged in to Teleport: ERROR: Not logged in.",
+		},
+		{
+			name: "exit code",
+			err:  &Error{ExitCode: 1, Stderr: "ERROR: something else", Err: errors.New("exit status 1")},
+			want: "tsh exited with code 1, stderr: ERROR: something else",
+		},
+		{
+			name: "unclassified stderr with exit code 0",
+			err:  &Error{Stderr: "ERROR: something else"},
+			want: "tsh exited with code 0, stderr: ERROR: something else",
+		},
+		{
+			name: "exit code 0 with error",
+			err:  &Error{Stderr: "ERROR: something else", Err: errors.New("no output")},
+			want: "no output, stderr: ERROR: something else",
+		},
+		{
+			name: "not run",
+			err:  &Error{ExitCode: -1, Err: exec.ErrNotFound},
+			want: "failed to execute tsh: executable file not found in $PATH",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
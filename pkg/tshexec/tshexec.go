@@ -0,0 +1,33 @@
+// Package tshexec creates the commands that run tsh, so that tests can
+// replace tsh with a fake.
+package tshexec
+
+import "os/exec"
+
+// Runner creates the commands that run tsh.
+type Runner interface {
+	// Command returns the command running tsh with args, not yet started.
+	Command(args ...string) *exec.Cmd
+}
+
+// RunnerFunc turns a function into a Runner.
+type RunnerFunc func(args ...string) *exec.Cmd
+
+// Command calls f.
+func (f RunnerFunc) Command(args ...string) *exec.Cmd {
+	return f(args...)
+}
+
+// Default runs the tsh found in PATH.
+var Default Runner = RunnerFunc(func(args ...string) *exec.Cmd {
+	return exec.Command("tsh", args...)
+})
+
+// Command returns the command running tsh with args using r, or Default if
+// r is nil.
+func Command(r Runner, args ...string) *exec.Cmd {
+	if r == nil {
+		r = Default
+	}
+	return r.Command(args...)
+}
@@ -0,0 +1,119 @@
+package tshfake
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Serves SOCKS5 without authentication on the listener, connecting to the
+// requested addresses or where they are routed to.
+func serveSOCKS5(dir string, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleSOCKS5(dir, conn)
+	}
+}
+
+func handleSOCKS5(dir string, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	addr, err := readSOCKS5Request(conn)
+	if err != nil {
+		return
+	}
+
+	if s, err := load(dir); err == nil && s.Routes[addr] != "" {
+		addr = s.Routes[addr]
+	}
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		// Host unreachable
+		_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = target.Close() }()
+
+	_, err = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, pair := range [][2]net.Conn{{conn, target}, {target, conn}} {
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(pair[0], pair[1])
+			if c, ok := pair[0].(*net.TCPConn); ok {
+				_ = c.CloseWrite()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Reads the greeting and the CONNECT request, and returns the requested
+// address as host:port.
+func readSOCKS5Request(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.ReadFull(conn, make([]byte, header[1]))
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Write([]byte{5, 0})
+	if err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	_, err = io.ReadFull(conn, request)
+	if err != nil {
+		return "", err
+	}
+	if request[1] != 1 {
+		return "", errors.New("only CONNECT is supported")
+	}
+
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if request[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		_, err = io.ReadFull(conn, ip)
+		host = ip.String()
+	case 3:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(conn, length)
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		_, err = io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return "", errors.New("unknown address type")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(conn, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
@@ -0,0 +1,233 @@
+package tshfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+// Time between checks of a running tunnel for its node going down or its
+// session ending.
+const pollInterval = 50 * time.Millisecond
+
+// Validity of a session started by `tsh login`.
+const loginValidity = 12 * time.Hour
+
+// Flags that don't take a value.
+var boolFlags = []string{"--no-remote-exec"}
+
+// Parsed command line of tsh.
+type command struct {
+	name  string
+	flags map[string]string
+	args  []string
+}
+
+func parse(args []string) command {
+	c := command{flags: map[string]string{}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case !strings.HasPrefix(arg, "--"):
+			if c.name == "" {
+				c.name = arg
+			} else {
+				c.args = append(c.args, arg)
+			}
+		case strings.Contains(arg, "="):
+			name, value, _ := strings.Cut(arg, "=")
+			c.flags[name] = value
+		case slices.Contains(boolFlags, arg) || i == len(args)-1:
+			c.flags[arg] = "true"
+		default:
+			c.flags[arg] = args[i+1]
+			i++
+		}
+	}
+	return c
+}
+
+// Runs tsh with args and returns its exit code.
+func run(dir string, args []string) int {
+	err := record(dir, args)
+	if err != nil {
+		return fail(err.Error())
+	}
+	s, err := load(dir)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	c := parse(args)
+	switch c.name {
+	case "status":
+		return status(s)
+	case "ls":
+		return ls(s, c)
+	case "ssh":
+		return ssh(dir, s, c)
+	case "login":
+		return login(dir, s, c)
+	case "logout":
+		s.logout(c.flags["--proxy"])
+		return save(dir, s)
+	default:
+		return fail(fmt.Sprintf("unknown command %q", c.name))
+	}
+}
+
+// Writes an error the way tsh does and returns its exit code.
+func fail(msg string) int {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", msg)
+	return 1
+}
+
+func save(dir string, s *state) int {
+	err := s.save(dir)
+	if err != nil {
+		return fail(err.Error())
+	}
+	return 0
+}
+
+// Returns the valid profile of the Teleport proxy, or the exit code of the
+// failure if there is none.
+func session(s *state, proxy string) (*profile, int) {
+	p := s.profile(proxy)
+	if p == nil {
+		return nil, fail("Not logged in.")
+	}
+	if !time.Now().Before(p.ValidUntil) {
+		return nil, fail("ssh: cert has expired")
+	}
+	return p, 0
+}
+
+// Prints the profiles as JSON, even expired ones like tsh does.
+func status(s *state) int {
+	if len(s.Profiles) == 0 {
+		return fail("Not logged in.")
+	}
+
+	var out tshstatus.Status
+	for _, p := range s.Profiles {
+		tp := &tshstatus.Profile{
+			ProfileURL: "https://" + p.Proxy + ":443",
+			Username:   "fake@example.com",
+			Cluster:    p.Proxy,
+			Logins:     p.Logins,
+			ValidUntil: p.ValidUntil,
+		}
+		if p.Proxy == s.Active {
+			out.Active = tp
+		} else {
+			out.Profiles = append(out.Profiles, tp)
+		}
+	}
+
+	err := json.NewEncoder(os.Stdout).Encode(out)
+	if err != nil {
+		return fail(err.Error())
+	}
+	return 0
+}
+
+// Prints the names of the nodes matching the selector.
+func ls(s *state, c command) int {
+	_, code := session(s, c.flags["--proxy"])
+	if code != 0 {
+		return code
+	}
+	if c.flags["--format"] != "names" {
+		return fail("only --format=names is supported")
+	}
+	if len(c.args) != 1 {
+		return fail("expected a label selector")
+	}
+
+	for _, node := range s.Nodes[c.args[0]] {
+		fmt.Println(node)
+	}
+	return 0
+}
+
+// Serves SOCKS5 on the forwarded port until the node goes down or the
+// session ends.
+func ssh(dir string, s *state, c command) int {
+	proxy := c.flags["--proxy"]
+	p, code := session(s, proxy)
+	if code != 0 {
+		return code
+	}
+
+	// LOGIN@node=NAME,SELECTOR
+	if len(c.args) != 1 {
+		return fail("expected a host")
+	}
+	login, host, ok := strings.Cut(c.args[0], "@")
+	nodeLabel, selector, _ := strings.Cut(host, ",")
+	node, found := strings.CutPrefix(nodeLabel, "node=")
+	if !ok || !found {
+		return fail(fmt.Sprintf("invalid host %q", c.args[0]))
+	}
+	if !slices.Contains(s.Nodes[selector], node) {
+		return fail(fmt.Sprintf("node not found: %s", host))
+	}
+	if !slices.Contains(p.Logins, login) {
+		return fail(fmt.Sprintf("access denied to %s connecting to %s", login, node))
+	}
+	if s.Down[node] {
+		return fail(fmt.Sprintf("dial tcp %s: connect: connection refused", node))
+	}
+
+	port := c.flags["--dynamic-forward"]
+	if port == "" {
+		return fail("only --dynamic-forward is supported")
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		return fail(err.Error())
+	}
+	go serveSOCKS5(dir, listener)
+
+	for {
+		time.Sleep(pollInterval)
+
+		s, err := load(dir)
+		if err != nil {
+			continue
+		}
+		_, code := session(s, proxy)
+		if code != 0 {
+			return code
+		}
+		if s.Down[node] {
+			_, _ = io.WriteString(os.Stderr, "ERROR: ssh: read: connection reset by peer\n")
+			return 255
+		}
+	}
+}
+
+// Logs in without asking for anything, keeping the logins of an earlier
+// session of the proxy.
+func login(dir string, s *state, c command) int {
+	proxy := c.flags["--proxy"]
+	if proxy == "" {
+		return fail("--proxy is required")
+	}
+
+	logins := []string{"root"}
+	if p := s.profile(proxy); p != nil {
+		logins = p.Logins
+	}
+	s.login(proxy, time.Now().Add(loginValidity), logins)
+	fmt.Printf("> Profile URL: https://%s:443\n", proxy)
+	return save(dir, s)
+}
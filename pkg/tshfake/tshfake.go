@@ -0,0 +1,312 @@
+// Package tshfake provides a scriptable fake of tsh for tests, so that
+// everything linkmeup does with Teleport can be tested without it.
+//
+// The fake runs as the test binary itself: call Main first thing in
+// TestMain, then create a Fake and use it as the tshexec.Runner. It
+// emulates `tsh status`, `tsh ls --format=names`, `tsh ssh --dynamic-forward`
+// with a real SOCKS5 server on the forwarded port, and `tsh login` and
+// `tsh logout`. Tests change the logins, nodes and routes while tsh runs,
+// and running tunnels react to it like real ones: they exit once their node
+// goes down or their session ends.
+package tshfake
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Environment variable telling the test binary to act as tsh, set to the
+// directory of the state.
+const stateEnv = "LINKMEUP_FAKE_TSH"
+
+const (
+	stateFile = "state.json"
+	callsFile = "calls"
+)
+
+// Main runs the fake tsh and exits if the test binary was started as tsh by
+// a Fake. Otherwise it returns right away, so tests run as usual.
+func Main() {
+	dir := os.Getenv(stateEnv)
+	if dir == "" {
+		return
+	}
+	os.Exit(run(dir, os.Args[1:]))
+}
+
+// Fake is a fake tsh whose state is kept in a temporary directory shared
+// with the tsh processes.
+type Fake struct {
+	tb  testing.TB
+	dir string
+	exe string
+
+	// Guards the state file against concurrent changes by the test
+	mu sync.Mutex
+}
+
+// New creates a fake tsh without any logins or nodes.
+func New(tb testing.TB) *Fake {
+	tb.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		tb.Fatalf("failed to find test binary: %v", err)
+	}
+
+	f := &Fake{tb: tb, dir: tb.TempDir(), exe: exe}
+	f.update(func(*state) {})
+	return f
+}
+
+// Command returns the command running the fake tsh with args. It
+// implements tshexec.Runner.
+func (f *Fake) Command(args ...string) *exec.Cmd {
+	cmd := exec.Command(f.exe, args...) //nolint:gosec
+	cmd.Env = append(os.Environ(), stateEnv+"="+f.dir)
+	return cmd
+}
+
+// Login logs in to the Teleport proxy until validUntil, allowing the given
+// SSH logins. The profile becomes the active one.
+func (f *Fake) Login(proxy string, validUntil time.Time, logins ...string) {
+	f.update(func(s *state) {
+		s.login(proxy, validUntil, logins)
+	})
+}
+
+// Expire lets the session of the Teleport proxy expire now.
+func (f *Fake) Expire(proxy string) {
+	f.update(func(s *state) {
+		if p := s.profile(proxy); p != nil {
+			p.ValidUntil = time.Now().Add(-time.Second)
+		}
+	})
+}
+
+// Logout logs out of the Teleport proxy.
+func (f *Fake) Logout(proxy string) {
+	f.update(func(s *state) {
+		s.logout(proxy)
+	})
+}
+
+// SetNodes sets the names of the nodes `tsh ls` lists for the selector.
+func (f *Fake) SetNodes(selector string, nodes ...string) {
+	f.update(func(s *state) {
+		s.Nodes[selector] = nodes
+	})
+}
+
+// SetDown sets whether the node is down. Tunnels to a node that is down
+// can't be opened, and running ones exit.
+func (f *Fake) SetDown(node string, down bool) {
+	f.update(func(s *state) {
+		s.Down[node] = down
+	})
+}
+
+// Route makes the tunnels connect to the address to instead of addr, which
+// is given as host:port like in SOCKS5 requests. Other addresses are
+// connected to directly.
+func (f *Fake) Route(addr, to string) {
+	f.update(func(s *state) {
+		s.Routes[addr] = to
+	})
+}
+
+// Calls returns the arguments of each tsh command run so far.
+func (f *Fake) Calls() [][]string {
+	f.tb.Helper()
+
+	file, err := os.Open(filepath.Join(f.dir, callsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		f.tb.Fatalf("failed to read tsh calls: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var calls [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var args []string
+		err := json.Unmarshal(scanner.Bytes(), &args)
+		if err != nil {
+			f.tb.Fatalf("failed to read tsh calls: %v", err)
+		}
+		calls = append(calls, args)
+	}
+	return calls
+}
+
+// Called returns the arguments of the tsh commands run so far whose first
+// arguments are prefix, like "ssh".
+func (f *Fake) Called(prefix ...string) [][]string {
+	var calls [][]string
+	for _, args := range f.Calls() {
+		if len(args) >= len(prefix) && slices.Equal(args[:len(prefix)], prefix) {
+			calls = append(calls, args)
+		}
+	}
+	return calls
+}
+
+// Applies change to the state file.
+func (f *Fake) update(change func(*state)) {
+	f.tb.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := load(f.dir)
+	if err != nil {
+		f.tb.Fatalf("failed to load tsh state: %v", err)
+	}
+	change(s)
+	err = s.save(f.dir)
+	if err != nil {
+		f.tb.Fatalf("failed to save tsh state: %v", err)
+	}
+}
+
+// What the fake knows about Teleport, shared by the test and the tsh
+// processes through the state file.
+type state struct {
+	// Proxy of the active profile, empty if not logged in
+	Active   string
+	Profiles []*profile
+	// Names of the nodes by label selector
+	Nodes map[string][]string
+	// Whether a node is down, by name
+	Down map[string]bool
+	// Addresses tunnels connect to instead of the requested ones
+	Routes map[string]string
+}
+
+// A profile the user is logged in to.
+type profile struct {
+	Proxy      string
+	Logins     []string
+	ValidUntil time.Time
+}
+
+// Returns the profile of the Teleport proxy, or the active one if proxy is
+// empty. Returns nil if not logged in.
+func (s *state) profile(proxy string) *profile {
+	if proxy == "" {
+		proxy = s.Active
+	}
+	if h, _, ok := strings.Cut(proxy, ":"); ok {
+		proxy = h
+	}
+	for _, p := range s.Profiles {
+		if strings.EqualFold(p.Proxy, proxy) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *state) login(proxy string, validUntil time.Time, logins []string) {
+	if h, _, ok := strings.Cut(proxy, ":"); ok {
+		proxy = h
+	}
+	p := s.profile(proxy)
+	if p == nil {
+		p = &profile{Proxy: proxy}
+		s.Profiles = append(s.Profiles, p)
+	}
+	p.Logins = logins
+	p.ValidUntil = validUntil
+	s.Active = proxy
+}
+
+func (s *state) logout(proxy string) {
+	p := s.profile(proxy)
+	s.Profiles = slices.DeleteFunc(s.Profiles, func(other *profile) bool { return other == p })
+	if p != nil && s.Active == p.Proxy {
+		s.Active = ""
+		if len(s.Profiles) > 0 {
+			s.Active = s.Profiles[0].Proxy
+		}
+	}
+}
+
+// Reads the state from the directory, empty if there is none yet.
+func load(dir string) (*state, error) {
+	s := &state{}
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		err = json.Unmarshal(data, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Nodes == nil {
+		s.Nodes = map[string][]string{}
+	}
+	if s.Down == nil {
+		s.Down = map[string]bool{}
+	}
+	if s.Routes == nil {
+		s.Routes = map[string]string{}
+	}
+	return s, nil
+}
+
+// Writes the state to the directory, replacing the file at once so that
+// tsh processes never read a partial one.
+func (s *state) save(dir string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, stateFile+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, stateFile))
+}
+
+// Records the arguments of a tsh command.
+func record(dir string, args []string) error {
+	line, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, callsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%s\n", line)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package tshfake
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
+)
+
+func TestMain(m *testing.M) {
+	Main()
+	os.Exit(m.Run())
+}
+
+func TestFake_status(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := New(t)
+
+	_, err := tshstatus.GetStatus(logger, fake)
+	if !errors.Is(err, tshstatus.ErrNotLoggedIn) {
+		t.Fatalf("GetStatus() error = %v, want %v", err, tshstatus.ErrNotLoggedIn)
+	}
+
+	validUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+	fake.Login("other.example.com", validUntil, "admin")
+	fake.Login("teleport.example.com", validUntil, "root")
+	status, err := tshstatus.GetStatus(logger, fake)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	active, err := status.Profile("")
+	if err != nil || active.ProxyHost() != "teleport.example.com" || !active.ValidUntil.Equal(validUntil) {
+		t.Errorf("active profile = %+v, %v, want teleport.example.com", active, err)
+	}
+	other, err := status.Profile("other.example.com:443")
+	if err != nil || !slices.Equal(other.Logins, []string{"admin"}) {
+		t.Errorf("Profile(other.example.com) = %+v, %v, want the admin login", other, err)
+	}
+
+	fake.Expire("other.example.com")
+	status, err = tshstatus.GetStatus(logger, fake)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if _, err := status.Profile("other.example.com"); !errors.Is(err, tshstatus.ErrProfileExpired) {
+		t.Errorf("Profile(other.example.com) error = %v, want %v", err, tshstatus.ErrProfileExpired)
+	}
+
+	// Logging in again through tsh keeps the logins
+	cmd := tshstatus.NewLoginCommand("other.example.com", "", "", nil)
+	cmd.Runner = fake
+	cmd.SetStdout(io.Discard)
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	status, err = tshstatus.GetStatus(logger, fake)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if p, err := status.Profile(""); err != nil || p.ProxyHost() != "other.example.com" || !slices.Equal(p.Logins, []string{"admin"}) {
+		t.Errorf("active profile after login = %+v, %v, want other.example.com with the admin login", p, err)
+	}
+
+	want := [][]string{{"login", "--proxy", "other.example.com"}}
+	if got := fake.Called("login"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Called(login) = %q, want %q", got, want)
+	}
+	if got := len(fake.Called("status", "--format=json")); got != 4 {
+		t.Errorf("tsh status called %d times, want 4", got)
+	}
+}
+
+func Test_parse(t *testing.T) {
+	c := parse([]string{"ssh", "--proxy", "teleport.example.com", "--no-remote-exec", "--dynamic-forward", "1081", "root@node=a,ins=one"})
+	if c.name != "ssh" || c.flags["--proxy"] != "teleport.example.com" || c.flags["--no-remote-exec"] != "true" || c.flags["--dynamic-forward"] != "1081" {
+		t.Errorf("parse() = %+v", c)
+	}
+	if !slices.Equal(c.args, []string{"root@node=a,ins=one"}) {
+		t.Errorf("args = %q, want the host", c.args)
+	}
+}
@@ -0,0 +1,116 @@
+package tshstatus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+)
+
+// NeedsLogin returns whether err from GetStatus means that the user has to
+// log in to Teleport again.
+func NeedsLogin(err error) bool {
+	return tsherr.NeedsLogin(err)
+}
+
+// LoginCommand runs `tsh login` interactively, letting tsh use the terminal
+// to ask for credentials or open the browser. It implements the ExecCommand
+// interface of bubbletea, so a TUI can hand over the terminal while it runs.
+type LoginCommand struct {
+	// The string passed to the `--proxy` flag
+	Proxy string
+	// The string passed to the `--auth` flag, if not empty
+	Auth string
+	// Teleport cluster to log in to, if not the root cluster of the proxy
+	Cluster string
+	// Whether to run `tsh logout` for the proxy first, to get rid of keys
+	// that don't form a valid keypair
+	Logout bool
+	// Runs tsh, tshexec.Default if nil
+	Runner tshexec.Runner
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewLoginCommand creates a login command for the Teleport proxy and
+// cluster, which logs out first if err from GetStatus asks for it.
+func NewLoginCommand(proxy, auth, cluster string, err error) *LoginCommand {
+	return &LoginCommand{
+		Proxy:   proxy,
+		Auth:    auth,
+		Cluster: cluster,
+		Logout:  errors.Is(err, ErrNoValidKeyPair),
+	}
+}
+
+func (c *LoginCommand) args() []string {
+	args := []string{"login", "--proxy", c.Proxy}
+	if c.Auth != "" {
+		args = append(args, "--auth", c.Auth)
+	}
+	if c.Cluster != "" {
+		args = append(args, c.Cluster)
+	}
+	return args
+}
+
+// String returns the command line, to show it to the user.
+func (c *LoginCommand) String() string {
+	login := "tsh " + strings.Join(c.args(), " ")
+	if c.Logout {
+		return "tsh logout --proxy " + c.Proxy + " && " + login
+	}
+	return login
+}
+
+// Run runs the command, using the standard streams unless others were set.
+func (c *LoginCommand) Run() error {
+	if c.Logout {
+		err := c.run("logout", "--proxy", c.Proxy)
+		if err != nil {
+			return fmt.Errorf("tsh logout failed: %w", err)
+		}
+	}
+
+	err := c.run(c.args()...)
+	if err != nil {
+		return fmt.Errorf("tsh login failed: %w", err)
+	}
+	return nil
+}
+
+func (c *LoginCommand) run(args ...string) error {
+	cmd := tshexec.Command(c.Runner, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if c.stdin != nil {
+		cmd.Stdin = c.stdin
+	}
+	if c.stdout != nil {
+		cmd.Stdout = c.stdout
+	}
+	if c.stderr != nil {
+		cmd.Stderr = c.stderr
+	}
+	return cmd.Run()
+}
+
+// SetStdin sets the input of tsh.
+func (c *LoginCommand) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+// SetStdout sets the output of tsh.
+func (c *LoginCommand) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+// SetStderr sets the error output of tsh.
+func (c *LoginCommand) SetStderr(w io.Writer) {
+	c.stderr = w
+}
@@ -0,0 +1,69 @@
+package tshstatus
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoginCommand(t *testing.T) {
+	// A tsh that prints its arguments
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "tsh"), []byte("#!/bin/sh\necho \"$@\"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	tests := []struct {
+		err        error
+		cluster    string
+		wantString string
+		wantOutput string
+	}{
+		{
+			err:        ErrActiveProfileExpired,
+			wantString: "tsh login --proxy teleport.example.com --auth github",
+			wantOutput: "login --proxy teleport.example.com --auth github\n",
+		},
+		{
+			err:        fmt.Errorf("status: %w", ErrNoValidKeyPair),
+			wantString: "tsh logout --proxy teleport.example.com && tsh login --proxy teleport.example.com --auth github",
+			wantOutput: "logout --proxy teleport.example.com\nlogin --proxy teleport.example.com --auth github\n",
+		},
+		{
+			err:        ErrProfileExpired,
+			cluster:    "leaf",
+			wantString: "tsh login --proxy teleport.example.com --auth github leaf",
+			wantOutput: "login --proxy teleport.example.com --auth github leaf\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if !NeedsLogin(tt.err) {
+				t.Errorf("NeedsLogin(%v) = false", tt.err)
+			}
+
+			cmd := NewLoginCommand("teleport.example.com", "github", tt.cluster, tt.err)
+			if got := cmd.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+
+			var out bytes.Buffer
+			cmd.SetStdout(&out)
+			err := cmd.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("tsh was run with %q, want %q", out.String(), tt.wantOutput)
+			}
+		})
+	}
+
+	if NeedsLogin(ErrEmptyCommandOutput) {
+		t.Error("NeedsLogin(ErrEmptyCommandOutput) = true")
+	}
+}
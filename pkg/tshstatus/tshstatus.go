@@ -7,26 +7,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os/exec"
 	"strings"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
 )
 
 var (
 	// ErrNotLoggedIn is returned when the user is not logged in to Teleport.
-	ErrNotLoggedIn = fmt.Errorf("user not logged in")
+	ErrNotLoggedIn = tsherr.ErrNotLoggedIn
+
+	// ErrActiveProfileExpired is returned when the session of the active
+	// profile has expired. It is the same as ErrProfileExpired.
+	ErrActiveProfileExpired = tsherr.ErrExpired
 
-	ErrActiveProfileExpired = fmt.Errorf("active profile expired")
+	// ErrProfileExpired is returned when the session of a profile has
+	// expired.
+	ErrProfileExpired = tsherr.ErrExpired
 
 	ErrEmptyCommandOutput = fmt.Errorf("command 'tsh status --format=json' yielded no output")
 
-	ErrNoValidKeyPair = fmt.Errorf("private and public keys do not form a valid keypair")
+	ErrNoValidKeyPair = tsherr.ErrInvalidKeyPair
 )
 
 // Executes 'tsh status --format=json' and returns the output as struct.
-// If no active profile is found, it returns nil.
-// If user is not logged in, it returns an NotLoggedInError.
-func GetStatus(logger *slog.Logger) (*Status, error) {
-	cmd := exec.Command("tsh", "status", "--format=json")
+// If no profile is found, it returns nil.
+// Failures of tsh are returned as *tsherr.Error, classified as ErrNotLoggedIn,
+// ErrProfileExpired, ErrNoValidKeyPair or another error of the tsherr
+// package if possible. tsh is run by runner, tshexec.Default if nil.
+func GetStatus(logger *slog.Logger, runner tshexec.Runner) (*Status, error) {
+	cmd := tshexec.Command(runner, "status", "--format=json")
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 	cmd.Stdout = &stdoutBuf
@@ -34,27 +44,16 @@ func GetStatus(logger *slog.Logger) (*Status, error) {
 
 	err := cmd.Run()
 	if err != nil {
-		stderr := strings.TrimSpace(stderrBuf.String())
-		if strings.Contains(strings.ToLower(stderr), "not logged in") {
-			return nil, ErrNotLoggedIn
-		}
-		if strings.Contains(strings.ToLower(stderr), "profile expired") {
-			return nil, ErrActiveProfileExpired
-		}
-		if strings.Contains(strings.ToLower(stderr), "private and public keys do not form a valid keypair") {
-			return nil, ErrNoValidKeyPair
-		}
-		return nil, fmt.Errorf("%v, stderr: %s", err, stderr)
+		return nil, tsherr.Wrap(err, stderrBuf.String())
 	}
 
 	// No stdout, so we check for an error
 	if strings.TrimSpace(stdoutBuf.String()) == "" {
-		if strings.Contains(strings.ToLower(stderrBuf.String()), "not logged in") {
-			return nil, ErrNotLoggedIn
-		}
-
 		logger.Debug("tsh status command yielded error", slog.String("stderr", stderrBuf.String()))
-		return nil, ErrEmptyCommandOutput
+
+		err := tsherr.Wrap(ErrEmptyCommandOutput, stderrBuf.String()).(*tsherr.Error)
+		err.ExitCode = cmd.ProcessState.ExitCode()
+		return nil, err
 	}
 
 	// Unmarshal the JSON output into a Status struct
@@ -63,7 +62,7 @@ func GetStatus(logger *slog.Logger) (*Status, error) {
 		return nil, err
 	}
 
-	if status.Active == nil || status.Active.ProfileURL == "" {
+	if (status.Active == nil || status.Active.ProfileURL == "") && len(status.Profiles) == 0 {
 		return nil, nil
 	}
 
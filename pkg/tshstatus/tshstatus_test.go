@@ -0,0 +1,49 @@
+package tshstatus
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/giantswarm/linkmeup/pkg/tsherr"
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+)
+
+func TestGetStatus_noOutput(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name     string
+		stderr   string
+		wantKind error
+	}{
+		{name: "expired", stderr: "ERROR: ssh: cert has expired", wantKind: tsherr.ErrExpired},
+		{name: "unknown", stderr: "something went wrong", wantKind: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A tsh that exits successfully without printing the status
+			runner := tshexec.RunnerFunc(func(args ...string) *exec.Cmd {
+				return exec.Command("sh", "-c", "echo \"$0\" >&2", tt.stderr)
+			})
+
+			_, err := GetStatus(logger, runner)
+			var tshErr *tsherr.Error
+			if !errors.As(err, &tshErr) {
+				t.Fatalf("GetStatus() error = %v, want a *tsherr.Error", err)
+			}
+			if tshErr.Kind != tt.wantKind || tshErr.ExitCode != 0 || tshErr.Stderr != tt.stderr {
+				t.Errorf("GetStatus() error = %+v, want kind %v, exit code 0 and the stderr", tshErr, tt.wantKind)
+			}
+			if !strings.Contains(err.Error(), tt.stderr) {
+				t.Errorf("GetStatus() error = %q, want the stderr in it", err)
+			}
+			if !errors.Is(err, ErrEmptyCommandOutput) {
+				t.Errorf("GetStatus() error = %v, want %v", err, ErrEmptyCommandOutput)
+			}
+		})
+	}
+}
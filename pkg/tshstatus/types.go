@@ -1,12 +1,51 @@
 package tshstatus
 
 import (
+	"net"
+	"net/url"
+	"strings"
 	"time"
 )
 
 // Status represents the output from `tsh status`
 type Status struct {
 	Active *Profile `json:"active,omitempty"`
+	// The other profiles the user is logged in to
+	Profiles []*Profile `json:"profiles,omitempty"`
+}
+
+// Profile returns the profile of the Teleport proxy at addr, given with or
+// without port, or the active profile if addr is empty. It returns
+// ErrNotLoggedIn if there is no such profile and ErrProfileExpired if it
+// has expired.
+func (s *Status) Profile(addr string) (*Profile, error) {
+	if s == nil {
+		return nil, ErrNotLoggedIn
+	}
+
+	var found *Profile
+	if addr == "" {
+		found = s.Active
+	} else {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		for _, p := range append([]*Profile{s.Active}, s.Profiles...) {
+			if p != nil && strings.EqualFold(p.ProxyHost(), host) {
+				found = p
+				break
+			}
+		}
+	}
+
+	if found == nil || found.ProfileURL == "" {
+		return nil, ErrNotLoggedIn
+	}
+	if !time.Now().Before(found.ValidUntil) {
+		return nil, ErrProfileExpired
+	}
+	return found, nil
 }
 
 // Profile represents a teleport profile
@@ -25,6 +64,15 @@ type Profile struct {
 	Extensions        []string  `json:"extensions"`
 }
 
+// ProxyHost returns the host name of the Teleport proxy of the profile.
+func (p *Profile) ProxyHost() string {
+	u, err := url.Parse(p.ProfileURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 // Traits represents the traits assigned to a user
 type Traits struct {
 	GithubTeams      []string `json:"github_teams"`
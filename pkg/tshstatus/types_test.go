@@ -0,0 +1,52 @@
+package tshstatus
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStatus_Profile(t *testing.T) {
+	// Shortened output of `tsh status --format=json`
+	data := `{
+		"active": {"profile_url": "https://teleport.example.com:443", "cluster": "example", "valid_until": "2999-01-01T00:00:00Z"},
+		"profiles": [
+			{"profile_url": "https://teleport.other.example.org:3080", "cluster": "other", "valid_until": "2999-01-01T00:00:00Z"},
+			{"profile_url": "https://teleport.old.example.net", "cluster": "old", "valid_until": "2000-01-01T00:00:00Z"}
+		]
+	}`
+	var status *Status
+	err := json.Unmarshal([]byte(data), &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		proxy       string
+		wantCluster string
+		wantErr     error
+	}{
+		{proxy: "", wantCluster: "example"},
+		{proxy: "teleport.example.com", wantCluster: "example"},
+		{proxy: "teleport.other.example.org:443", wantCluster: "other"},
+		{proxy: "Teleport.Other.Example.org", wantCluster: "other"},
+		{proxy: "teleport.old.example.net", wantErr: ErrProfileExpired},
+		{proxy: "teleport.unknown.example.com", wantErr: ErrNotLoggedIn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.proxy, func(t *testing.T) {
+			profile, err := status.Profile(tt.proxy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Profile() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && profile.Cluster != tt.wantCluster {
+				t.Errorf("Profile() cluster = %q, want %q", profile.Cluster, tt.wantCluster)
+			}
+		})
+	}
+
+	var none *Status
+	if _, err := none.Profile(""); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Profile() of nil status error = %v, want %v", err, ErrNotLoggedIn)
+	}
+}
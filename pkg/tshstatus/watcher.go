@@ -0,0 +1,219 @@
+package tshstatus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+)
+
+const (
+	// DefaultCheckInterval is the default time between polls of the session.
+	DefaultCheckInterval = time.Minute
+
+	// DefaultWarnBefore is the default time before the session expires at
+	// which a warning is logged.
+	DefaultWarnBefore = 15 * time.Minute
+)
+
+// Session is the state of the Teleport session as seen by a Watcher.
+type Session struct {
+	ValidUntil time.Time
+	// Whether the session has expired or the user logged out
+	Expired bool
+	// Why the session ended, if it has
+	Err error
+	// Whether the session expires within the warning period
+	ExpiresSoon bool
+}
+
+// Remaining returns the time until the session expires, 0 if it has.
+func (s Session) Remaining() time.Duration {
+	if s.Expired {
+		return 0
+	}
+	return max(time.Until(s.ValidUntil), 0)
+}
+
+// Watcher polls `tsh status` in the background to notice when the Teleport
+// session of a profile is about to expire, has expired, or was renewed by a
+// new login.
+type Watcher struct {
+	logger *slog.Logger
+	// Teleport proxy of the profile, empty for the active one
+	proxy      string
+	interval   time.Duration
+	warnBefore time.Duration
+	// Returns the current status, GetStatus unless replaced in tests
+	getStatus func(*slog.Logger, tshexec.Runner) (*Status, error)
+
+	// Runs tsh, tshexec.Default if nil. Set before Run.
+	Runner tshexec.Runner
+	// Called once the session expired, with the reason. Set before Run.
+	OnExpired func(reason string)
+	// Called with the new status once a new login was detected, which may
+	// happen before the previous session expired. Set before Run.
+	OnLogin func(status *Status)
+
+	mu         sync.Mutex
+	validUntil time.Time
+	// Why the session ended, nil while it is valid
+	err error
+	// Whether the warning for the current session was logged
+	warned bool
+	// Whether the last check failed without telling anything about the
+	// session, like when tsh could not reach Teleport
+	failed bool
+}
+
+// NewWatcher creates a watcher for the session of the profile of the
+// Teleport proxy in status, or the active profile if proxy is empty. It
+// polls every interval and warns warnBefore the session expires.
+func NewWatcher(logger *slog.Logger, status *Status, proxy string, interval, warnBefore time.Duration) (*Watcher, error) {
+	profile, err := status.Profile(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid check interval: %s", interval)
+	}
+	if warnBefore < 0 {
+		return nil, fmt.Errorf("invalid warning period: %s", warnBefore)
+	}
+
+	return &Watcher{
+		logger:     logger,
+		proxy:      proxy,
+		interval:   interval,
+		warnBefore: warnBefore,
+		getStatus:  GetStatus,
+		validUntil: profile.ValidUntil,
+	}, nil
+}
+
+// Session returns the current state of the session.
+func (w *Watcher) Session() Session {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return Session{
+		ValidUntil:  w.validUntil,
+		Expired:     w.err != nil,
+		Err:         w.err,
+		ExpiresSoon: w.err == nil && time.Until(w.validUntil) <= w.warnBefore,
+	}
+}
+
+// Run polls the session until ctx is cancelled. Besides the regular polls,
+// it checks when the warning is due and when the session expires.
+func (w *Watcher) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(w.nextCheck())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		w.Check()
+	}
+}
+
+// Returns the time until the next check.
+func (w *Watcher) nextCheck() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next := w.interval
+	if w.err != nil {
+		return next
+	}
+	if !w.warned && !w.failed {
+		// Due once, as a successful check logs the warning. After a failed
+		// check, the next one is not due before the interval, so that a
+		// passed deadline doesn't make tsh run over and over.
+		next = min(next, time.Until(w.validUntil.Add(-w.warnBefore)))
+	}
+	next = min(next, time.Until(w.validUntil))
+	return max(next, 0)
+}
+
+// Check polls the status and acts on changes of the session. Run calls it
+// regularly, but it can be called to notice a new login right away.
+func (w *Watcher) Check() {
+	status, err := w.getStatus(w.logger, w.Runner)
+
+	w.mu.Lock()
+	w.failed = err != nil && !NeedsLogin(err)
+	w.mu.Unlock()
+
+	switch {
+	case NeedsLogin(err):
+		w.expire(err)
+	case err != nil:
+		w.logger.Warn("Failed to check Teleport session", slog.String("error", err.Error()))
+		// The session has expired anyway if it was due
+		if w.Session().Remaining() == 0 {
+			w.expire(ErrProfileExpired)
+		}
+	default:
+		profile, err := status.Profile(w.proxy)
+		if err != nil {
+			w.expire(err)
+			return
+		}
+		w.update(status, profile)
+	}
+}
+
+// Marks the session as expired, notifying OnExpired the first time.
+func (w *Watcher) expire(err error) {
+	w.mu.Lock()
+	if w.err != nil {
+		w.mu.Unlock()
+		return
+	}
+	w.err = err
+	w.mu.Unlock()
+
+	w.logger.Warn("Teleport session expired, tunnels are stopped until you log in again", slog.String("proxy", w.proxy), slog.String("reason", err.Error()))
+	if w.OnExpired != nil {
+		w.OnExpired(err.Error())
+	}
+}
+
+// Takes over the valid profile from status, notifying OnLogin if it belongs
+// to a new session, and warns if the session expires soon.
+func (w *Watcher) update(status *Status, profile *Profile) {
+	validUntil := profile.ValidUntil
+
+	w.mu.Lock()
+	login := w.err != nil || !validUntil.Equal(w.validUntil)
+	if login {
+		w.validUntil = validUntil
+		w.err = nil
+		w.warned = false
+	}
+	warn := !w.warned && time.Until(validUntil) <= w.warnBefore
+	if warn {
+		w.warned = true
+	}
+	w.mu.Unlock()
+
+	if login {
+		w.logger.Info("New Teleport session detected", slog.String("proxy", profile.ProxyHost()), slog.Time("valid_until", validUntil))
+		if w.OnLogin != nil {
+			w.OnLogin(status)
+		}
+	}
+	if warn {
+		w.logger.Warn("Teleport session expires soon, log in again to keep the tunnels running",
+			slog.String("proxy", profile.ProxyHost()),
+			slog.Duration("remaining", time.Until(validUntil).Round(time.Second)),
+			slog.Time("valid_until", validUntil))
+	}
+}
@@ -0,0 +1,127 @@
+package tshstatus
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/linkmeup/pkg/tshexec"
+)
+
+func testStatus(validUntil time.Time) *Status {
+	return &Status{Active: &Profile{ProfileURL: "https://teleport.example.com", ValidUntil: validUntil}}
+}
+
+func TestWatcher(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w, err := NewWatcher(logger, testStatus(time.Now().Add(time.Hour)), "", time.Minute, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+
+	var expired []string
+	var logins []*Status
+	w.OnExpired = func(reason string) { expired = append(expired, reason) }
+	w.OnLogin = func(status *Status) { logins = append(logins, status) }
+
+	var current *Status
+	var currentErr error
+	w.getStatus = func(*slog.Logger, tshexec.Runner) (*Status, error) { return current, currentErr }
+
+	// Same session, nothing happens
+	current = testStatus(w.Session().ValidUntil)
+	w.Check()
+	if len(expired) != 0 || len(logins) != 0 {
+		t.Fatalf("unchanged session: expired = %v, logins = %d", expired, len(logins))
+	}
+	if s := w.Session(); s.Expired || s.ExpiresSoon {
+		t.Errorf("Session() = %+v, want valid", s)
+	}
+
+	// Logged out, expired once
+	current, currentErr = nil, ErrNotLoggedIn
+	w.Check()
+	w.Check()
+	if len(expired) != 1 {
+		t.Fatalf("OnExpired called %d times, want 1", len(expired))
+	}
+	if s := w.Session(); !s.Expired || s.Remaining() != 0 || !errors.Is(s.Err, ErrNotLoggedIn) {
+		t.Errorf("Session() = %+v, want expired", s)
+	}
+
+	// Logged in again, with a short session
+	current, currentErr = testStatus(time.Now().Add(10*time.Minute)), nil
+	w.Check()
+	if len(logins) != 1 || logins[0] != current {
+		t.Fatalf("OnLogin got %v, want the new status", logins)
+	}
+	s := w.Session()
+	if s.Expired || !s.ExpiresSoon || !s.ValidUntil.Equal(current.Active.ValidUntil) {
+		t.Errorf("Session() = %+v, want valid and expiring soon", s)
+	}
+
+	// Renewed before it expired
+	current = testStatus(time.Now().Add(time.Hour))
+	w.Check()
+	if len(logins) != 2 {
+		t.Errorf("OnLogin called %d times, want 2", len(logins))
+	}
+	if next := w.nextCheck(); next != time.Minute {
+		t.Errorf("nextCheck() = %s, want the interval", next)
+	}
+
+	// The status still reports a session that has run out
+	current = testStatus(time.Now().Add(-time.Second))
+	w.Check()
+	if len(expired) != 2 {
+		t.Errorf("OnExpired called %d times, want 2", len(expired))
+	}
+}
+
+func TestWatcher_nextCheck(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w, err := NewWatcher(logger, testStatus(time.Now().Add(20*time.Minute)), "", time.Hour, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+
+	// Due when the warning is
+	if next := w.nextCheck(); next > 5*time.Minute || next < 4*time.Minute {
+		t.Errorf("nextCheck() = %s, want about 5m", next)
+	}
+
+	// Due when the session expires once warned
+	w.warned = true
+	if next := w.nextCheck(); next > 20*time.Minute || next < 19*time.Minute {
+		t.Errorf("nextCheck() = %s, want about 20m", next)
+	}
+}
+
+func TestWatcher_nextCheck_failing(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w, err := NewWatcher(logger, testStatus(time.Now().Add(10*time.Minute)), "", time.Minute, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	w.getStatus = func(*slog.Logger, tshexec.Runner) (*Status, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	// Inside the warning period, the warning is due right away
+	if next := w.nextCheck(); next != 0 {
+		t.Errorf("nextCheck() = %s, want 0", next)
+	}
+
+	// But not again while checks fail
+	for range 3 {
+		w.Check()
+		if next := w.nextCheck(); next != time.Minute {
+			t.Fatalf("nextCheck() after failed check = %s, want the interval", next)
+		}
+	}
+	if s := w.Session(); s.Expired {
+		t.Errorf("Session() = %+v, want not expired by failed checks", s)
+	}
+}
@@ -2,20 +2,30 @@
 package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
 	"charm.land/lipgloss/v2/table"
+	"github.com/charmbracelet/x/ansi"
 
+	"github.com/giantswarm/linkmeup/pkg/logging"
 	"github.com/giantswarm/linkmeup/pkg/proxy"
+	"github.com/giantswarm/linkmeup/pkg/tshstatus"
 )
 
+// Number of log entries shown in the log pane
+const logPaneLines = 10
+
 var (
 	// Column widths
-	colWidths = []int{20, 35, 12, 6, 7, 25}
+	colWidths = []int{20, 35, 15, 6, 7, 25}
 
 	titleStyle = lipgloss.NewStyle().
 			Bold(true).
@@ -54,30 +64,75 @@ var (
 			Foreground(lipgloss.Color("#5a4fcf")).
 			Bold(true).
 			Underline(true)
+
+	logPaneStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("#626262")).
+			Padding(0, 1).
+			MarginTop(1)
 )
 
-// tickMsg is sent periodically to update the status
-type tickMsg time.Time
+// Session is a Teleport session shown in the TUI.
+type Session struct {
+	// Name of the Teleport profile, shown if not empty
+	Name    string
+	Watcher *tshstatus.Watcher
+	// Command to log in to the profile again, nil if not known
+	Login *tshstatus.LoginCommand
+}
+
+// eventMsg is sent when one of the proxies published an event
+type eventMsg struct {
+	event proxy.Event
+}
+
+// logMsg is sent when entries were added to the log buffer
+type logMsg struct{}
+
+// tickMsg is sent every second to update the session countdown
+type tickMsg struct{}
+
+// loginMsg is sent when `tsh login` has given back the terminal
+type loginMsg struct {
+	err error
+}
 
 // Model represents the TUI state.
 type Model struct {
 	proxies  []*proxy.Proxy
+	events   <-chan proxy.Event
+	logs     *logging.Buffer
+	sessions []Session
 	rows     [][]string
 	pacURL   string
+	socksURL string
+	httpURL  string
 	quitting bool
 	width    int
 	height   int
-	lastTick time.Time
 	cursor   int
+	// Whether the log pane is shown
+	showLogs bool
+	// Whether the log pane only shows entries of the selected installation
+	filterLogs bool
+	// Error of the last `tsh login`, if it failed
+	loginErr error
 }
 
 // New creates a new TUI model.
-func New(proxies []*proxy.Proxy, pacPort int) Model {
+// The HTTP proxy is only shown if httpPort is not 0.
+func New(proxies []*proxy.Proxy, pacURL string, socksPort int, httpPort int) Model {
+	httpURL := ""
+	if httpPort != 0 {
+		httpURL = fmt.Sprintf("http://localhost:%d", httpPort)
+	}
+
 	return Model{
 		proxies:  proxies,
 		rows:     buildRows(proxies),
-		pacURL:   fmt.Sprintf("http://localhost:%d/proxy.pac", pacPort),
-		lastTick: time.Now(),
+		pacURL:   pacURL,
+		socksURL: fmt.Sprintf("socks5://localhost:%d", socksPort),
+		httpURL:  httpURL,
 	}
 }
 
@@ -103,11 +158,21 @@ func buildRows(proxies []*proxy.Proxy) [][]string {
 }
 
 func formatStatus(status proxy.ProxyStatus) string {
-	switch {
-	case status.NodeCount == 0:
-		return pendingStyle.Render("- No Nodes")
-	case status.Healthy:
+	switch status.State {
+	case proxy.StateHealthy:
 		return healthyStyle.Render("✓ Healthy")
+	case proxy.StateNoNodes:
+		return pendingStyle.Render("- No Nodes")
+	case proxy.StateStarting, proxy.StateConnecting:
+		return pendingStyle.Render("… Connecting")
+	case proxy.StateRestarting:
+		return pendingStyle.Render("↻ Restarting")
+	case proxy.StateStopped:
+		return pendingStyle.Render("■ Stopped")
+	case proxy.StateAuthExpired:
+		return unhealthyStyle.Render("✗ Auth Expired")
+	case proxy.StateFailed:
+		return unhealthyStyle.Render("✗ Failed")
 	default:
 		return unhealthyStyle.Render("✗ Unhealthy")
 	}
@@ -115,15 +180,44 @@ func formatStatus(status proxy.ProxyStatus) string {
 
 // Init implements tea.Model.
 func (m Model) Init() tea.Cmd {
-	return tickCmd()
+	return tea.Batch(waitForEvent(m.events), waitForLogs(m.logs), m.tick())
 }
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
-		return tickMsg(t)
+// Schedules the next update of the session countdown.
+func (m Model) tick() tea.Cmd {
+	if len(m.sessions) == 0 {
+		return nil
+	}
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return tickMsg{}
 	})
 }
 
+// Waits for the next proxy event.
+func waitForEvent(events <-chan proxy.Event) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		e, ok := <-events
+		if !ok {
+			return nil
+		}
+		return eventMsg{event: e}
+	}
+}
+
+// Waits for entries to be added to the log buffer.
+func waitForLogs(logs *logging.Buffer) tea.Cmd {
+	if logs == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		<-logs.Changed()
+		return logMsg{}
+	}
+}
+
 // Update implements tea.Model.
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -140,16 +234,49 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.cursor < len(m.proxies)-1 {
 				m.cursor++
 			}
+		case "l":
+			if m.logs != nil {
+				m.showLogs = !m.showLogs
+			}
+		case "f":
+			m.filterLogs = !m.filterLogs
+		case "t":
+			if s, ok := m.loginSession(); ok {
+				// Suspends the TUI while tsh owns the terminal
+				cmd := tshstatus.NewLoginCommand(s.Login.Proxy, s.Login.Auth, s.Login.Cluster, s.Watcher.Session().Err)
+				cmd.Runner = s.Login.Runner
+				return m, tea.Exec(cmd, func(err error) tea.Msg {
+					return loginMsg{err: err}
+				})
+			}
 		}
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 
-	case tickMsg:
+	case eventMsg:
 		m.rows = buildRows(m.proxies)
-		m.lastTick = time.Time(msg)
-		return m, tickCmd()
+		return m, waitForEvent(m.events)
+
+	case logMsg:
+		// Re-rendered with the new entries
+		return m, waitForLogs(m.logs)
+
+	case tickMsg:
+		// Re-rendered with the new countdown
+		return m, m.tick()
+
+	case loginMsg:
+		m.loginErr = msg.err
+		// Resumes the proxies right away if there is a new session
+		sessions := m.sessions
+		return m, func() tea.Msg {
+			for _, s := range sessions {
+				s.Watcher.Check()
+			}
+			return nil
+		}
 	}
 
 	return m, nil
@@ -190,10 +317,35 @@ func (m Model) View() tea.View {
 	b.WriteString(t.Render())
 	b.WriteString("\n")
 
+	// Details of the selected proxy
+	if m.cursor < len(m.proxies) {
+		b.WriteString(formatDetails(m.proxies[m.cursor].Status()))
+		b.WriteString("\n")
+	}
+
+	if m.showLogs {
+		b.WriteString(m.formatLogs())
+		b.WriteString("\n")
+	}
+
 	// PAC URL info
 	b.WriteString("\n")
 	b.WriteString(fmt.Sprintf("  PAC URL: %s", pacURLStyle.Render(m.pacURL)))
 	b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("  SOCKS5 proxy: %s", pacURLStyle.Render(m.socksURL)))
+	b.WriteString("\n")
+	if m.httpURL != "" {
+		b.WriteString(fmt.Sprintf("  HTTP proxy: %s", pacURLStyle.Render(m.httpURL)))
+		b.WriteString("\n")
+	}
+	for _, s := range m.sessions {
+		b.WriteString("  " + formatSession(s))
+		b.WriteString("\n")
+	}
+	if m.loginErr != nil {
+		b.WriteString("  " + unhealthyStyle.Render(m.loginErr.Error()))
+		b.WriteString("\n")
+	}
 
 	// Status counts - use same symbols as table
 	healthy, unhealthy, noNodes := countStatus(m.proxies)
@@ -207,13 +359,150 @@ func (m Model) View() tea.View {
 	b.WriteString("\n")
 
 	// Help
-	b.WriteString(helpStyle.Render("  ↑/↓: Navigate • q/Esc: Quit"))
+	help := "  ↑/↓: Navigate"
+	if m.logs != nil {
+		help += " • l: Logs • f: Filter logs"
+	}
+	if _, ok := m.loginSession(); ok {
+		help += " • t: Teleport login"
+	}
+	b.WriteString(helpStyle.Render(help + " • q/Esc: Quit"))
 
 	v := tea.NewView(b.String())
 	v.AltScreen = true
 	return v
 }
 
+func formatDetails(status proxy.ProxyStatus) string {
+	details := fmt.Sprintf("  %s: %s since %s", status.Name, status.State, status.StateSince.Format(time.TimeOnly))
+	if status.StateReason != "" {
+		details += " - " + status.StateReason
+	}
+	return helpStyle.Render(details)
+}
+
+// Returns the session the login key logs in to: the first that expired,
+// or else the first that expires soon, or else the first. Only sessions
+// with a login command count.
+func (m Model) loginSession() (Session, bool) {
+	var soon, first *Session
+	for i := range m.sessions {
+		s := &m.sessions[i]
+		if s.Login == nil {
+			continue
+		}
+		state := s.Watcher.Session()
+		if state.Expired {
+			return *s, true
+		}
+		if state.ExpiresSoon && soon == nil {
+			soon = s
+		}
+		if first == nil {
+			first = s
+		}
+	}
+	switch {
+	case soon != nil:
+		return *soon, true
+	case first != nil:
+		return *first, true
+	default:
+		return Session{}, false
+	}
+}
+
+// Renders the remaining time of a Teleport session, pointing to the login
+// key if it can log in to it.
+func formatSession(s Session) string {
+	name := "Teleport session"
+	if s.Name != "" {
+		name += " " + s.Name
+	}
+	hint := "log in again"
+	if s.Login != nil {
+		hint = "press t to log in"
+	}
+
+	state := s.Watcher.Session()
+	switch {
+	case state.Expired:
+		return unhealthyStyle.Render(fmt.Sprintf("✗ %s expired, %s to resume the tunnels", name, hint))
+	case state.ExpiresSoon:
+		return pendingStyle.Render(fmt.Sprintf("! %s expires in %s, %s soon", name, formatRemaining(state.Remaining()), hint))
+	default:
+		return fmt.Sprintf("%s: %s left", name, formatRemaining(state.Remaining()))
+	}
+}
+
+// Formats a duration to the second, like 1h02m03s.
+func formatRemaining(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, sec := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, sec)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%dm%02ds", m, sec)
+	}
+	return fmt.Sprintf("%ds", sec)
+}
+
+// Renders the most recent log entries, only the ones of the selected
+// installation if filtered.
+func (m Model) formatLogs() string {
+	title := "Logs"
+	installation := ""
+	if m.filterLogs && m.cursor < len(m.proxies) {
+		installation = m.proxies[m.cursor].Name
+		title = "Logs of " + installation
+	}
+
+	var lines []string
+	entries := m.logs.Entries()
+	for i := len(entries) - 1; i >= 0 && len(lines) < logPaneLines; i-- {
+		e := entries[i]
+		if installation != "" && e.Installation != installation {
+			continue
+		}
+		lines = append(lines, formatLogEntry(e))
+	}
+	slices.Reverse(lines)
+	if len(lines) == 0 {
+		lines = append(lines, helpStyle.Render("No entries yet"))
+	}
+
+	if m.width > 0 {
+		// Keep one entry per line, leaving room for border and padding
+		for i, line := range lines {
+			lines[i] = ansi.Truncate(line, max(m.width-4, 1), "…")
+		}
+	}
+	return logPaneStyle.Render(headerStyle.Render(" "+title+" ") + "\n" + strings.Join(lines, "\n"))
+}
+
+func formatLogEntry(e logging.Entry) string {
+	level := fmt.Sprintf("%-5s", e.Level)
+	switch {
+	case e.Level >= slog.LevelError:
+		level = unhealthyStyle.Render(level)
+	case e.Level >= slog.LevelWarn:
+		level = pendingStyle.Render(level)
+	default:
+		level = helpStyle.Render(level)
+	}
+
+	line := fmt.Sprintf("%s %s ", e.Time.Format(time.TimeOnly), level)
+	if e.Installation != "" {
+		line += "[" + e.Installation + "] "
+	}
+	line += e.Message
+	if e.Attrs != "" {
+		line += " " + helpStyle.Render(e.Attrs)
+	}
+	return line
+}
+
 func sum(a []int) int {
 	s := 0
 	for _, v := range a {
@@ -224,22 +513,35 @@ func sum(a []int) int {
 
 func countStatus(proxies []*proxy.Proxy) (healthy, unhealthy, noNodes int) {
 	for _, p := range proxies {
-		status := p.Status()
-		if status.NodeCount == 0 {
-			noNodes++
-		} else if status.Healthy {
+		switch p.Status().State {
+		case proxy.StateHealthy:
 			healthy++
-		} else {
+		case proxy.StateNoNodes:
+			noNodes++
+		default:
 			unhealthy++
 		}
 	}
 	return
 }
 
-// Run starts the TUI.
-func Run(proxies []*proxy.Proxy, pacPort int) error {
-	m := New(proxies, pacPort)
-	p := tea.NewProgram(m)
+// Run starts the TUI. It is updated whenever one of the proxies publishes an
+// event. If logs is not nil, its entries can be shown in a log pane. For each
+// of the sessions, the time left until it expires is shown, and its login
+// command can be run to log in again, handing over the terminal to tsh.
+func Run(ctx context.Context, proxies []*proxy.Proxy, logs *logging.Buffer, sessions []Session, pacURL string, socksPort int, httpPort int) error {
+	events, unsubscribe := proxy.SubscribeAll(proxies)
+	defer unsubscribe()
+
+	m := New(proxies, pacURL, socksPort, httpPort)
+	m.events = events
+	m.logs = logs
+	m.sessions = sessions
+	p := tea.NewProgram(m, tea.WithContext(ctx))
 	_, err := p.Run()
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		// Cancelled from outside, e.g. by a signal
+		return nil
+	}
 	return err
 }